@@ -2,10 +2,14 @@ module github.com/IvanDobriy/configushka
 
 go 1.22.2
 
-require github.com/stretchr/testify v1.9.0
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/hashicorp/hcl v1.0.0
+	github.com/stretchr/testify v1.9.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
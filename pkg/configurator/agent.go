@@ -1,32 +1,149 @@
 package configurator
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
+	"reflect"
+	"slices"
+	"sync"
 	"time"
 )
 
 type UpdateFunc func(r io.Reader, format string) error
 
+// UpdateContextFunc is an UpdateFunc which is told through ctx when Configure is cancelled
+// or the timeout of the agent expires.
+type UpdateContextFunc func(ctx context.Context, r io.Reader, format string) error
+
 type Agent interface {
+	// Require makes the agent depend on agent, in place of a requirement with the same name.
+	// Once the agent is registered, agent and its requirements are registered too, unless
+	// that introduces a cycle or a name clash, in which case the previous requirement stays.
 	Require(agent Agent) error
+	// RequireOptional makes the agent depend on agent only while an agent with its name is
+	// registered. Such an agent is neither registered by the call nor needed to configure.
+	RequireOptional(agent Agent) error
+	// After orders the agent after agent while an agent with its name is registered. Unlike
+	// with the requirements, a failure of agent does not keep the agent from updating.
+	After(agent Agent) error
 	update(r io.ReadSeeker, format string) error
 	addParent(agent Agent) error
+	addChild(agent Agent)
+	removeParent(agent Agent)
+	removeChild(agent Agent)
 	childrenExists() bool
+	children() []Agent
+	dependents() []Agent
+	links() map[string]linkKind
+	configure(ctx context.Context, s *snapshot) error
+	prepare(ctx context.Context, s *snapshot) error
+	commit() error
+	rollback() error
+	markConfigured()
+	markUnchanged()
 	moduleName() string
-	isConfigured(time time.Time) bool
+	isConfigured(since time.Time) bool
 	signUp(registry Registry) error
+	setRegistry(registry Registry)
+	validate(document map[string]any) []Violation
+	fingerprint(document map[string]any) string
+	lastFingerprint() string
+	setFingerprint(fingerprint string)
+	status() AgentStatus
+}
+
+// AgentStatus describes the outcome of the updates of an agent.
+type AgentStatus struct {
+	Name string
+	// Configured is set once the agent was updated successfully, its settings stay applied
+	// while a reload is in progress or after a reload failed. ConfiguredAt is the time of
+	// the last successful update.
+	Configured   bool
+	ConfiguredAt time.Time
+	// Current is set once the agent was configured in the current epoch of its registry, so
+	// it is unset while a reload has not reached the agent yet, see Registry.Reset.
+	Current bool
+	// LastError is the error of the last update attempt, nil if it succeeded.
+	LastError error
+}
+
+// linkKind tells how an agent depends on an agent it is linked to by name.
+type linkKind int
+
+const (
+	// optionalLink is made by RequireOptional.
+	optionalLink linkKind = iota + 1
+	// orderLink is made by After.
+	orderLink
+)
+
+// AgentOption customizes the agent created by NewAgent or NewTypedAgent.
+type AgentOption func(a *agentImpl)
+
+// WithSection declares the dot separated path of the document section the agent reads.
+// Without a section the agent is considered to read the whole document.
+func WithSection(section string) AgentOption {
+	return func(a *agentImpl) {
+		a.section = section
+	}
+}
+
+// WithSchema declares the expected shape of the agent section by a struct value, see
+// Violation for the checks done. The document is validated before any agent is updated.
+func WithSchema(schema any) AgentOption {
+	return func(a *agentImpl) {
+		a.schema = reflect.TypeOf(schema)
+	}
+}
+
+// WithTransaction makes the update callback the first phase of a two-phase update: it
+// should only prepare the new settings, which commit applies and rollback discards or, once
+// committed, reverts. See WithTransactional for how the phases are driven.
+func WithTransaction(commit func() error, rollback func() error) AgentOption {
+	return func(a *agentImpl) {
+		a.commitHook = commit
+		a.rollbackHook = rollback
+	}
+}
+
+// WithTimeout limits the time the update callback of the agent may take. When it expires
+// the callback is abandoned and Configure fails with a *TimeoutError for the agent.
+func WithTimeout(timeout time.Duration) AgentOption {
+	return func(a *agentImpl) {
+		a.timeout = timeout
+	}
 }
 
-func NewAgent(name string, updateCallback UpdateFunc) Agent {
+func NewAgent(name string, updateCallback UpdateFunc, options ...AgentOption) Agent {
 	agent := &agentImpl{
 		name:           name,
 		parents:        make(map[string]Agent),
 		childrens:      make(map[string]Agent),
+		linked:         make(map[string]linkKind),
 		updateCallback: updateCallback,
 		time:           nil,
-		isHandled:      false,
+	}
+	for _, option := range options {
+		option(agent)
+	}
+	return agent
+}
+
+// NewAgentContext creates an agent whose callback receives the context of the Configure
+// run, limited by the timeout of the agent if any.
+func NewAgentContext(name string, updateCallback UpdateContextFunc, options ...AgentOption) Agent {
+	agent := NewAgent(name, nil, options...).(*agentImpl)
+	agent.apply = func(ctx context.Context, s *snapshot) error {
+		r, err := s.reader()
+		if err != nil {
+			return err
+		}
+		return updateCallback(ctx, r, s.format)
 	}
 	return agent
 }
@@ -35,33 +152,113 @@ type agentImpl struct {
 	name           string
 	parents        map[string]Agent
 	childrens      map[string]Agent
+	linked         map[string]linkKind
 	updateCallback UpdateFunc
+	apply          func(ctx context.Context, s *snapshot) error
+	section        string
+	schema         reflect.Type
+	commitHook     func() error
+	rollbackHook   func() error
+	timeout        time.Duration
+	mutex          sync.Mutex
 	time           *time.Time
-	isHandled      bool
+	lastError      error
+	registry       Registry
+	// configuredEpoch and handledEpoch are the epochs of the registry in which the agent was
+	// last configured and visited by update, zero if never.
+	configuredEpoch uint64
+	handledEpoch    uint64
+	// applied is the fingerprint of the section the agent was last updated from.
+	applied string
+	// onConfigured is called once an update is committed, onRolledBack once it is rolled
+	// back.
+	onConfigured func()
+	onRolledBack func()
 }
 
 func (a *agentImpl) Require(agent Agent) error {
+	name := agent.moduleName()
 	_ = agent.addParent(a)
-	a.childrens[agent.moduleName()] = agent
+	a.mutex.Lock()
+	previous, existed := a.childrens[name]
+	a.childrens[name] = agent
+	registry := a.registry
+	a.mutex.Unlock()
+	var err error
+	if registry != nil {
+		err = findCycle([]Agent{a}, resolver(registry, a))
+		if err == nil {
+			err = registry.Register(agent)
+		}
+	}
+	if err == nil {
+		if existed && previous != agent {
+			previous.removeParent(a)
+		}
+		return nil
+	}
+	if existed && previous == agent {
+		return err
+	}
+	a.mutex.Lock()
+	if existed {
+		a.childrens[name] = previous
+	} else {
+		delete(a.childrens, name)
+	}
+	a.mutex.Unlock()
+	agent.removeParent(a)
+	return err
+}
+
+func (a *agentImpl) RequireOptional(agent Agent) error {
+	return a.link(agent.moduleName(), optionalLink)
+}
+
+func (a *agentImpl) After(agent Agent) error {
+	return a.link(agent.moduleName(), orderLink)
+}
+
+// link replaces the link to the agent called name. The link is undone when it closes a
+// cycle among the registered agents.
+func (a *agentImpl) link(name string, kind linkKind) error {
+	a.mutex.Lock()
+	previous, existed := a.linked[name]
+	a.linked[name] = kind
+	registry := a.registry
+	a.mutex.Unlock()
+	if registry == nil {
+		return nil
+	}
+	if err := findCycle([]Agent{a}, resolver(registry, a)); err != nil {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		if existed {
+			a.linked[name] = previous
+		} else {
+			delete(a.linked, name)
+		}
+		return err
+	}
 	return nil
 }
 
 func (a *agentImpl) update(r io.ReadSeeker, format string) error {
 	now := time.Now()
-	if a.isHandled {
+	a.mutex.Lock()
+	epoch := a.epoch()
+	if a.handledEpoch == epoch {
+		a.mutex.Unlock()
 		return nil
 	}
-	a.isHandled = true
+	a.handledEpoch = epoch
+	a.mutex.Unlock()
 
-	var leaf Agent = nil
-	for _, agent := range a.childrens {
-		if !agent.isConfigured(now) {
-			leaf = agent
-			break
+	for _, agent := range a.children() {
+		if agent.isConfigured(now) {
+			continue
 		}
-	}
-	if leaf != nil {
-		if err := leaf.update(r, format); err != nil {
+		if err := agent.update(r, format); err != nil {
 			return err
 		}
 	}
@@ -72,12 +269,14 @@ func (a *agentImpl) update(r io.ReadSeeker, format string) error {
 	if _, err := r.Seek(0, 0); err != nil {
 		return err
 	}
-
-	if err := a.updateCallback(r, format); err != nil {
+	raw, err := io.ReadAll(r)
+	if err != nil {
 		return err
 	}
-	a.time = &now
-	for _, agent := range a.parents {
+	if err := a.configure(context.Background(), newSnapshot(raw, format, nil)); err != nil {
+		return err
+	}
+	for _, agent := range a.dependents() {
 		if err := agent.update(r, format); err != nil {
 			return err
 		}
@@ -85,36 +284,273 @@ func (a *agentImpl) update(r io.ReadSeeker, format string) error {
 	return nil
 }
 
+// configure updates the agent alone, its children are expected to be configured already.
+// A transactional agent is committed right away.
+func (a *agentImpl) configure(ctx context.Context, s *snapshot) error {
+	if err := a.prepare(ctx, s); err != nil {
+		return err
+	}
+	if err := a.commit(); err != nil {
+		if rollbackErr := a.rollback(); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	a.markConfigured()
+	return nil
+}
+
+// prepare runs the update callback. When ctx can be cancelled or the agent has a timeout,
+// the callback runs in its own goroutine and is abandoned once ctx is done.
+func (a *agentImpl) prepare(ctx context.Context, s *snapshot) (err error) {
+	defer func() {
+		a.setLastError(err)
+	}()
+	parent := ctx
+	if a.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeout)
+		defer cancel()
+	}
+	if ctx.Done() == nil {
+		return a.invoke(ctx, s)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- a.invoke(ctx, s)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		select {
+		case err := <-result:
+			return err
+		default:
+		}
+		if a.timeout > 0 && parent.Err() == nil {
+			return &TimeoutError{Timeout: a.timeout}
+		}
+		return ctx.Err()
+	}
+}
+
+func (a *agentImpl) invoke(ctx context.Context, s *snapshot) error {
+	if a.apply != nil {
+		return a.apply(ctx, s)
+	}
+	r, err := s.reader()
+	if err != nil {
+		return err
+	}
+	return a.updateCallback(r, s.format)
+}
+
+func (a *agentImpl) commit() error {
+	if a.commitHook == nil {
+		return nil
+	}
+	err := a.commitHook()
+	if err != nil {
+		a.setLastError(err)
+	}
+	return err
+}
+
+func (a *agentImpl) rollback() error {
+	if a.onRolledBack != nil {
+		a.onRolledBack()
+	}
+	if a.rollbackHook == nil {
+		return nil
+	}
+	return a.rollbackHook()
+}
+
+func (a *agentImpl) markConfigured() {
+	now := time.Now()
+	a.mutex.Lock()
+	a.time = &now
+	a.configuredEpoch = a.epoch()
+	a.mutex.Unlock()
+	if a.onConfigured != nil {
+		a.onConfigured()
+	}
+}
+
+// markUnchanged marks the agent as configured in the current epoch without an update, for
+// a section found unchanged. The time of the last update is kept.
+func (a *agentImpl) markUnchanged() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.configuredEpoch = a.epoch()
+}
+
+// epoch returns the current epoch of the registry of the agent, agents outside of a
+// registry stay in the first epoch.
+func (a *agentImpl) epoch() uint64 {
+	if a.registry == nil {
+		return firstEpoch
+	}
+	return a.registry.epoch()
+}
+
+func (a *agentImpl) setLastError(err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.lastError = err
+}
+
+func (a *agentImpl) status() AgentStatus {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	status := AgentStatus{Name: a.name, LastError: a.lastError}
+	if a.time != nil {
+		status.Configured = true
+		status.ConfiguredAt = *a.time
+		status.Current = a.configuredEpoch == a.epoch()
+	}
+	return status
+}
+
 func (a *agentImpl) moduleName() string {
 	return a.name
 }
 
 func (a *agentImpl) addParent(agent Agent) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
 	a.parents[agent.moduleName()] = agent
 	return nil
 }
 
+func (a *agentImpl) addChild(agent Agent) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.childrens[agent.moduleName()] = agent
+}
+
+func (a *agentImpl) removeParent(agent Agent) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.parents[agent.moduleName()] == agent {
+		delete(a.parents, agent.moduleName())
+	}
+}
+
+func (a *agentImpl) removeChild(agent Agent) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.childrens[agent.moduleName()] == agent {
+		delete(a.childrens, agent.moduleName())
+	}
+}
+
 func (a *agentImpl) childrenExists() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
 	return len(a.childrens) > 0
 }
 
-func (a *agentImpl) isConfigured(time time.Time) bool {
-	return a.time != nil
+func (a *agentImpl) children() []Agent {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return sortedAgents(a.childrens)
+}
+
+func (a *agentImpl) dependents() []Agent {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return sortedAgents(a.parents)
+}
+
+func (a *agentImpl) links() map[string]linkKind {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	links := make(map[string]linkKind, len(a.linked))
+	for name, kind := range a.linked {
+		links[name] = kind
+	}
+	return links
+}
+
+// isConfigured tells whether the agent was configured in the current epoch no earlier than
+// since.
+func (a *agentImpl) isConfigured(since time.Time) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.time != nil && a.configuredEpoch == a.epoch() && !a.time.Before(since)
+}
+
+func (a *agentImpl) validate(document map[string]any) []Violation {
+	if a.schema == nil {
+		return nil
+	}
+	node, _ := lookupSection(document, a.section)
+	return validateSchema(a.section, node, a.schema)
+}
+
+// fingerprint hashes the section of the agent, or the whole document for an agent without
+// a section. It is empty when the section can not be hashed.
+func (a *agentImpl) fingerprint(document map[string]any) string {
+	node, _ := lookupSection(document, a.section)
+	return fingerprintOf(node)
+}
+
+// fingerprintOf hashes a decoded value, it returns an empty string for a value which can
+// not be hashed.
+func fingerprintOf(node any) string {
+	raw, err := json.Marshal(node)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *agentImpl) lastFingerprint() string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.applied
+}
+
+func (a *agentImpl) setFingerprint(fingerprint string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.applied = fingerprint
 }
 
 func (a *agentImpl) signUp(registry Registry) error {
-	oldAgent := registry.get(a.name)
-	if oldAgent != nil {
-		if oldAgent != a {
-			return errors.New(fmt.Sprintf("found different agents with same name %v", a.name))
+	if registered, claimed := registry.claim(a.name, a); !claimed {
+		if registered != a {
+			return &DuplicateAgentError{Name: a.name}
 		}
 		return nil
 	}
-	registry.set(a.name, a)
-	for _, agent := range a.childrens {
+	for _, agent := range a.children() {
 		if err := agent.signUp(registry); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+func (a *agentImpl) setRegistry(registry Registry) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.registry = registry
+}
+
+func sortedAgents(agents map[string]Agent) []Agent {
+	list := make([]Agent, 0, len(agents))
+	for _, agent := range agents {
+		list = append(list, agent)
+	}
+	slices.SortFunc(list, func(x, y Agent) int {
+		return cmp.Compare(x.moduleName(), y.moduleName())
+	})
+	return list
+}
@@ -259,3 +259,27 @@ func TestUpdateLoop(t *testing.T) {
 
 	assert.Equal([]string{"4", "3", "2", "1"}, sequence)
 }
+
+func TestUpdateAllChildren(t *testing.T) {
+	assert := assertions.New(t)
+	sequence := make([]string, 0)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error {
+		sequence = append(sequence, "1")
+		return nil
+	})
+	agent2 := NewAgent("2", func(r io.Reader, format string) error {
+		sequence = append(sequence, "2")
+		return nil
+	})
+	agent3 := NewAgent("3", func(r io.Reader, format string) error {
+		sequence = append(sequence, "3")
+		return nil
+	})
+	_ = agent1.Require(agent3)
+	_ = agent1.Require(agent2)
+	buffer := strings.NewReader("hello, world\n")
+	reader := io.NewSectionReader(buffer, 0, buffer.Size())
+	err := agent1.update(reader, "123")
+	assert.Nil(err)
+	assert.Equal([]string{"2", "3", "1"}, sequence)
+}
@@ -1,56 +1,291 @@
 package configurator
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"os"
 	"strings"
+	"time"
 )
 
 type Configurator interface {
+	// Configure loads the configuration and updates the registered agents, every agent
+	// after its requirements. Each run starts a new epoch of the registry, in which every
+	// agent is updated again, unless the previous run failed on the same document: the run
+	// then resumes the epoch of the failed run, the agents it configured are reported
+	// UpToDate and left alone.
+	// Registry.Reset makes the next run start a new epoch in any case.
 	Configure() error
+	// ConfigureContext works like Configure. Once ctx is done no more agents are started
+	// and the callbacks in progress are abandoned.
+	ConfigureContext(ctx context.Context) error
+	// ConfigureReport works like ConfigureContext and reports what was done with every
+	// agent. The report is nil when the run fails before any agent is considered, for
+	// example because no configuration file could be loaded.
+	ConfigureReport(ctx context.Context) (*Report, error)
 }
 
-func NewLocalConfigurator(registry Registry, configPaths []string, format string) Configurator {
+// Option customizes the configurator created by NewLocalConfigurator.
+type Option func(c *configuratorImpl)
+
+// WithParallelism lets up to workers agents be updated at the same time. An agent is
+// started once all of its requirements are configured, every callback reads its own copy
+// of the configuration.
+func WithParallelism(workers int) Option {
+	return func(c *configuratorImpl) {
+		c.workers = max(workers, 1)
+	}
+}
+
+// WithMerge loads every existing configuration path instead of the first one only and deep
+// merges them in order, so that later files override earlier ones. Agents receive the
+// merged document encoded in the format of the first file.
+func WithMerge(policy MergePolicy) Option {
+	return func(c *configuratorImpl) {
+		c.merge = true
+		c.policy = policy
+	}
+}
+
+// WithSource layers the values of the source over the loaded files. Sources are applied in
+// the order they are given, so the last one takes precedence.
+func WithSource(source Source) Option {
+	return func(c *configuratorImpl) {
+		c.sources = append(c.sources, source)
+	}
+}
+
+// WithContinueOnError keeps updating the agents after a failure. Only the agents which
+// depend on a failed one, directly or not, are skipped and Configure returns a
+// *ConfigureError describing every failure.
+func WithContinueOnError() Option {
+	return func(c *configuratorImpl) {
+		c.continueOnError = true
+	}
+}
+
+// WithChangeDetection skips the agents whose section of the document did not change since
+// their last update, unless one of their requirements is updated in the same run. Agents
+// without a section depend on the whole document. After Registry.Reset every agent is
+// updated once more. See Report for the decisions made.
+func WithChangeDetection() Option {
+	return func(c *configuratorImpl) {
+		c.detectChanges = true
+	}
+}
+
+func NewLocalConfigurator(registry Registry, configPaths []string, format string, options ...Option) Configurator {
 	configurator := &configuratorImpl{
 		registry: registry,
 		paths:    configPaths,
 		format:   strings.ToLower(format),
+		workers:  1,
+	}
+	for _, option := range options {
+		option(configurator)
 	}
 	return configurator
 }
 
 type configuratorImpl struct {
-	registry Registry
-	paths    []string
-	format   string
+	registry        Registry
+	paths           []string
+	format          string
+	workers         int
+	merge           bool
+	policy          MergePolicy
+	sources         []Source
+	transactional   bool
+	continueOnError bool
+	detectChanges   bool
+	secrets         map[string]SecretResolver
+	interpolate     bool
+}
+
+func (c *configuratorImpl) Configure() error {
+	return c.ConfigureContext(context.Background())
+}
+
+func (c *configuratorImpl) ConfigureContext(ctx context.Context) error {
+	_, err := c.configure(ctx, false)
+	return err
+}
+
+func (c *configuratorImpl) ConfigureReport(ctx context.Context) (*Report, error) {
+	return c.configure(ctx, false)
 }
 
-func (c *configuratorImpl) Configure() (err error) {
-	registeredAgents := c.registry.getAll()
+// configure runs exclusively over the registry. A new epoch is started once the
+// configuration is loaded, unless the epoch was left open by a failed run over the same
+// document and reset is not set.
+func (c *configuratorImpl) configure(ctx context.Context, reset bool) (*Report, error) {
+	defer c.registry.beginRun()()
+	registeredAgents, err := c.registry.order()
+	if err != nil {
+		return nil, err
+	}
+	conf, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	if err = c.validate(registeredAgents, conf); err != nil {
+		return nil, err
+	}
+	document, err := conf.tree()
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := fingerprintOf(document)
+	resume := !reset && fingerprint != "" && c.registry.openEpoch() == fingerprint
+	if !resume {
+		c.registry.nextEpoch()
+	}
+	recorder := newRecorder()
+	pending := make([]Agent, 0, len(registeredAgents))
+	for _, agent := range registeredAgents {
+		if agent.isConfigured(time.Time{}) {
+			recorder.record(agent, UpToDate, "", nil)
+		} else {
+			pending = append(pending, agent)
+		}
+	}
+	if c.transactional {
+		err = c.configureTransaction(ctx, pending, conf, recorder)
+	} else {
+		err = c.run(ctx, pending, func(agent Agent) error {
+			return c.update(ctx, agent, conf, recorder)
+		})
+	}
+	if err != nil {
+		c.registry.setOpenEpoch(fingerprint)
+	} else {
+		c.registry.setOpenEpoch("")
+	}
+	return recorder.report(registeredAgents), err
+}
+
+// update configures the agent unless change detection finds it unchanged.
+func (c *configuratorImpl) update(ctx context.Context, agent Agent, conf *snapshot, recorder *recorder) error {
+	fingerprint, changed, reason := c.detectChange(agent, conf, recorder)
+	if !changed {
+		agent.markUnchanged()
+		recorder.record(agent, Unchanged, reason, nil)
+		return nil
+	}
+	if err := agent.configure(ctx, conf); err != nil {
+		agent.setFingerprint("")
+		recorder.record(agent, Failed, reason, err)
+		return err
+	}
+	agent.setFingerprint(fingerprint)
+	recorder.record(agent, Updated, reason, nil)
+	return nil
+}
+
+// detectChange fingerprints the section of the agent and tells whether the agent has to be
+// updated and why. Without change detection every agent has to.
+func (c *configuratorImpl) detectChange(agent Agent, conf *snapshot, recorder *recorder) (fingerprint string, changed bool, reason string) {
+	document, err := conf.tree()
+	if err != nil {
+		return "", true, ""
+	}
+	fingerprint = agent.fingerprint(document)
+	if !c.detectChanges {
+		return fingerprint, true, ""
+	}
+	switch previous := agent.lastFingerprint(); {
+	case previous == "":
+		return fingerprint, true, "not updated before"
+	case previous != fingerprint || fingerprint == "":
+		return fingerprint, true, "section changed"
+	}
+	requirements := agentNames(agent.children())
+	links := agent.links()
+	for _, name := range sortedNames(links) {
+		if links[name] == optionalLink {
+			requirements = append(requirements, name)
+		}
+	}
+	for _, name := range requirements {
+		if recorder.decision(name) == Updated {
+			return fingerprint, true, fmt.Sprintf("requirement %v updated", name)
+		}
+	}
+	return fingerprint, false, "section unchanged"
+}
+
+// load reads the first existing configuration file or, in merge mode, all of them, applies
+// the sources on top and expands the references and secrets.
+func (c *configuratorImpl) load() (*snapshot, error) {
 	if len(c.paths) == 0 {
-		return errors.New("configuration file paths is empty")
+		return nil, ErrNoConfigPaths
 	}
-	var conf *os.File = nil
+	var conf *snapshot = nil
+	merged := false
+	causes := make([]error, 0, len(c.paths))
 	for _, path := range c.paths {
-		file, error := os.OpenFile(path, os.O_RDONLY, 0640)
-		if error == nil {
-			conf = file
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			causes = append(causes, err)
+			continue
+		}
+		format := c.format
+		if format == "" {
+			if format, err = detectFormat(path); err != nil {
+				return nil, err
+			}
+		}
+		document, err := decodeDocument(raw, format)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		if conf == nil {
+			conf = newSnapshot(raw, format, document)
+		} else {
+			conf.document = merge(conf.document, document, c.policy)
+			merged = true
+		}
+		if !c.merge {
 			break
 		}
 	}
 	if conf == nil {
-		return errors.New("no config files found")
+		return nil, &NoConfigFileError{Tried: c.paths, Causes: causes}
 	}
-
-	defer func() {
-		if error := conf.Close(); error != nil {
-			err = error
+	for _, source := range c.sources {
+		overlay, err := source.Load()
+		if err != nil {
+			return nil, err
 		}
-	}()
-	for _, agent := range registeredAgents {
-		if err = agent.update(conf, c.format); err != nil {
-			return
+		if len(overlay) > 0 {
+			conf.document = merge(conf.document, overlay, ReplaceLists)
+			merged = true
 		}
 	}
-	return
+	if c.secrets != nil || c.interpolate {
+		changed, err := expandDocument(conf.document, newInterpolator(c, conf.document).expand)
+		if err != nil {
+			return nil, err
+		}
+		merged = merged || changed
+	}
+	conf.stale = merged
+	return conf, nil
+}
+
+// validate checks the document against the schemas of all agents, so that no agent is
+// updated from an invalid document.
+func (c *configuratorImpl) validate(agents []Agent, conf *snapshot) error {
+	document, err := conf.tree()
+	if err != nil {
+		return err
+	}
+	violations := make([]Violation, 0)
+	for _, agent := range agents {
+		violations = append(violations, agent.validate(document)...)
+	}
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
 }
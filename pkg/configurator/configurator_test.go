@@ -1,11 +1,13 @@
 package configurator
 
 import (
+	"context"
+	"errors"
 	assertions "github.com/stretchr/testify/assert"
 	"io"
 	"os"
 	"path/filepath"
-	"slices"
+	"sync"
 	"testing"
 	"time"
 )
@@ -155,9 +157,6 @@ func TestConfigure2LevelHierarchy(t *testing.T) {
 
 func TestConfigureWithLoop(t *testing.T) {
 	assert := assertions.New(t)
-	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
-	assert.Nil(err)
-	now := time.Now()
 	sequence := make([]string, 0)
 	agent1 := NewAgent("1", func(r io.Reader, format string) error {
 		sequence = append(sequence, "1")
@@ -182,13 +181,376 @@ func TestConfigureWithLoop(t *testing.T) {
 	agent4.Require(agent1)
 
 	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.NotNil(err)
+	assert.Nil(registry)
+	assert.Empty(sequence)
+}
+
+func TestConfigureTopologicalOrder(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
 	assert.Nil(err)
-	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+	for i := 0; i < 10; i++ {
+		sequence := make([]string, 0)
+		newAgent := func(name string) Agent {
+			return NewAgent(name, func(r io.Reader, format string) error {
+				sequence = append(sequence, name)
+				return nil
+			})
+		}
+		db := newAgent("db")
+		cache := newAgent("cache")
+		logger := newAgent("logger")
+		server := newAgent("server")
+		metrics := newAgent("metrics")
+		server.Require(db)
+		server.Require(cache)
+		cache.Require(logger)
+		db.Require(logger)
+
+		registry, err := NewModuleRegistry([]Agent{metrics, server})
+		assert.Nil(err)
+		configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+		err = configurator.Configure()
+		assert.Nil(err)
+		order, err := registry.Order()
+		assert.Nil(err)
+		assert.Equal([]string{"logger", "cache", "db", "metrics", "server"}, sequence)
+		assert.Equal(order, sequence)
+	}
+}
+
+func TestConfigureParallel(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	raw, err := os.ReadFile(path)
+	assert.Nil(err)
+	now := time.Now()
+	var mutex sync.Mutex
+	running := 0
+	maxRunning := 0
+	configured := make(map[string]bool)
+	newAgent := func(name string, requirements ...string) Agent {
+		return NewAgent(name, func(r io.Reader, format string) error {
+			mutex.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			for _, requirement := range requirements {
+				assert.True(configured[requirement], "%v configured before %v", name, requirement)
+			}
+			mutex.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			buffer, ioerr := io.ReadAll(r)
+			assert.Equal(string(raw), string(buffer))
+			mutex.Lock()
+			running--
+			configured[name] = true
+			mutex.Unlock()
+			return ioerr
+		})
+	}
+	server := newAgent("server", "db", "cache")
+	db := newAgent("db", "logger")
+	cache := newAgent("cache", "logger")
+	logger := newAgent("logger")
+	metrics := newAgent("metrics")
+	tracing := newAgent("tracing")
+	server.Require(db)
+	server.Require(cache)
+	db.Require(logger)
+	cache.Require(logger)
+
+	registry, err := NewModuleRegistry([]Agent{server, metrics, tracing})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithParallelism(2))
 
 	err = configurator.Configure()
 	assert.Nil(err)
-	slices.Sort(sequence) //maybe random
-	assert.Equal([]string{"1", "2", "3", "4"}, sequence)
+	assert.Equal(2, maxRunning)
+	assert.Len(configured, 6)
+	for _, agent := range []Agent{server, db, cache, logger, metrics, tracing} {
+		assert.True(agent.isConfigured(now))
+	}
+}
+
+func TestConfigureParallelError(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	now := time.Now()
+	someError := errors.New("some error")
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return someError })
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	agent1.Require(agent3)
+
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithParallelism(4))
+
+	err = configurator.Configure()
+	assert.Equal(&AgentError{Agent: "2", Err: someError}, err)
+	assert.False(agent1.isConfigured(now))
+	assert.False(agent2.isConfigured(now))
+	assert.True(agent3.isConfigured(now))
+}
+
+func TestConfigureConcurrent(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	var mutex sync.Mutex
+	running := 0
+	maxRunning := 0
+	calls := 0
+	agent1 := NewAgent("1", func(r io.Reader, format string) error {
+		mutex.Lock()
+		running++
+		calls++
+		maxRunning = max(maxRunning, running)
+		mutex.Unlock()
+		time.Sleep(time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml").(*configuratorImpl)
+	now := time.Now()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := configurator.configure(context.Background(), true)
+			assert.Nil(err)
+			assert.Nil(configurator.Configure())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(1, maxRunning)
+	assert.Equal(20, calls)
 	assert.True(agent1.isConfigured(now))
+}
+
+func TestConfigureStatus(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	someError := errors.New("some error")
+	fail := true
+	agent1 := NewAgent("1", func(r io.Reader, format string) error {
+		if fail {
+			return someError
+		}
+		return nil
+	})
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+	status, err := registry.Status("1")
+	assert.Nil(err)
+	assert.Equal(AgentStatus{Name: "1"}, status)
+
+	before := time.Now()
+	assert.ErrorIs(configurator.Configure(), someError)
+	status, err = registry.Status("1")
+	assert.Nil(err)
+	assert.False(status.Configured)
+	assert.Equal(someError, status.LastError)
+	status, err = registry.Status("2")
+	assert.Nil(err)
+	assert.True(status.Configured)
+	assert.False(status.ConfiguredAt.Before(before))
+	assert.Nil(status.LastError)
+
+	fail = false
+	assert.Nil(configurator.Configure())
+	status, err = registry.Status("1")
+	assert.Nil(err)
+	assert.True(status.Configured)
+	assert.True(status.Current)
+	assert.Nil(status.LastError)
+
+	// after a failed reload the agents keep the settings they were configured with
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	agent3.Require(agent1)
+	assert.Nil(registry.Register(agent3))
+	assert.Nil(configurator.Configure())
+	fail = true
+	assert.ErrorIs(configurator.Configure(), someError)
+	status, err = registry.Status("3")
+	assert.Nil(err)
+	assert.True(status.Configured)
+	assert.False(status.Current)
+	assert.Nil(status.LastError)
+}
+
+func TestConfigureReplacement(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	updates := make([]string, 0)
+	newAgent := func(name string, tag string) Agent {
+		return NewAgent(name, func(r io.Reader, format string) error {
+			updates = append(updates, tag)
+			return nil
+		})
+	}
+	agent1 := newAgent("1", "1")
+	agent2 := newAgent("2", "old 2")
+	agent1.Require(agent2)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+	assert.Nil(configurator.Configure())
+	assert.Equal([]string{"old 2", "1"}, updates)
+
+	assert.Nil(registry.Replace(newAgent("2", "new 2")))
+	assert.Nil(configurator.Configure())
+	assert.Equal([]string{"old 2", "1", "new 2", "1"}, updates)
+}
+
+func TestConfigureAgentRequiredLater(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	now := time.Now()
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+	assert.Nil(configurator.Configure())
+
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	assert.Nil(agent1.Require(agent2))
+	assert.False(agent2.isConfigured(now))
+	assert.Nil(configurator.Configure())
 	assert.True(agent2.isConfigured(now))
 }
+
+func TestConfigureAfterReset(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	updates := 0
+	agent1 := NewAgent("1", func(r io.Reader, format string) error {
+		updates++
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+	assert.Nil(configurator.Configure())
+	assert.Nil(configurator.Configure())
+	assert.Equal(2, updates)
+	first, err := registry.Status("1")
+	assert.Nil(err)
+	assert.True(first.Configured)
+	time.Sleep(time.Millisecond)
+	afterFirst := time.Now()
+	assert.False(agent1.isConfigured(afterFirst))
+
+	for i := 3; i <= 4; i++ {
+		registry.Reset()
+		status, err := registry.Status("1")
+		assert.Nil(err)
+		assert.True(status.Configured)
+		assert.False(status.Current)
+		assert.False(agent1.isConfigured(time.Time{}))
+
+		assert.Nil(configurator.Configure())
+		assert.Equal(i, updates)
+		assert.True(agent1.isConfigured(afterFirst))
+		status, err = registry.Status("1")
+		assert.Nil(err)
+		assert.True(status.Current)
+		assert.True(status.ConfiguredAt.After(first.ConfiguredAt))
+	}
+}
+
+func TestConfigureResumesFailedRun(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	someError := errors.New("some error")
+	fail := true
+	agent1 := NewAgent("1", func(r io.Reader, format string) error {
+		if fail {
+			return someError
+		}
+		return nil
+	})
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+	_, err = configurator.ConfigureReport(context.Background())
+	assert.ErrorIs(err, someError)
+	fail = false
+	report, err := configurator.ConfigureReport(context.Background())
+	assert.Nil(err)
+	assert.Equal(&Report{Agents: []AgentReport{
+		{Name: "2", Decision: UpToDate},
+		{Name: "1", Decision: Updated},
+	}}, report)
+	report, err = configurator.ConfigureReport(context.Background())
+	assert.Nil(err)
+	assert.Equal(&Report{Agents: []AgentReport{
+		{Name: "2", Decision: Updated},
+		{Name: "1", Decision: Updated},
+	}}, report)
+
+	// a reset starts a new epoch even after a failed run
+	fail = true
+	_, err = configurator.ConfigureReport(context.Background())
+	assert.ErrorIs(err, someError)
+	registry.Reset()
+	fail = false
+	report, err = configurator.ConfigureReport(context.Background())
+	assert.Nil(err)
+	decision, _ := report.Agent("2")
+	assert.Equal(Updated, decision.Decision)
+}
+
+func TestConfigureRestartsFailedRunOnChangedDocument(t *testing.T) {
+	assert := assertions.New(t)
+	path := filepath.Join(t.TempDir(), "test.config.yaml")
+	assert.Nil(os.WriteFile(path, []byte("a: 1\nb: 1\n"), 0640))
+	a := 0
+	agentA := NewTypedAgent("a", "a", func(value int) error {
+		a = value
+		return nil
+	})
+	agentB := NewTypedAgent("b", "b", func(value int) error {
+		if value == 1 {
+			return errors.New("b can not be 1")
+		}
+		return nil
+	})
+	agentB.Require(agentA)
+	registry, err := NewModuleRegistry([]Agent{agentB})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+	assert.NotNil(configurator.Configure())
+	assert.Equal(1, a)
+
+	assert.Nil(os.WriteFile(path, []byte("a: 2\nb: 2\n"), 0640))
+	report, err := configurator.ConfigureReport(context.Background())
+	assert.Nil(err)
+	assert.Equal(2, a)
+	decision, _ := report.Agent("a")
+	assert.Equal(Updated, decision.Decision)
+}
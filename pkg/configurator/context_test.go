@@ -0,0 +1,104 @@
+package configurator
+
+import (
+	"context"
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigureContextAgentTimeout(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	now := time.Now()
+	release := make(chan struct{})
+	defer close(release)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("db", func(r io.Reader, format string) error {
+		<-release
+		return nil
+	}, WithTimeout(20*time.Millisecond))
+	agent1.Require(agent2)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+	err = configurator.Configure()
+	assert.Equal(&AgentError{Agent: "db", Err: &TimeoutError{Timeout: 20 * time.Millisecond}}, err)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.EqualError(err, "agent db: timed out after 20ms")
+	assert.False(agent1.isConfigured(now))
+	assert.False(agent2.isConfigured(now))
+}
+
+func TestConfigureContextCallback(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	var deadline time.Time
+	agent1 := NewAgentContext("1", func(ctx context.Context, r io.Reader, format string) error {
+		deadline, _ = ctx.Deadline()
+		assert.Equal("yaml", format)
+		return nil
+	}, WithTimeout(time.Minute))
+	agent2 := NewAgentContext("2", func(ctx context.Context, r io.Reader, format string) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithTimeout(10*time.Millisecond))
+	registry, err := NewModuleRegistry([]Agent{agent1, agent2})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+	err = configurator.ConfigureContext(context.Background())
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	var agentError *AgentError
+	assert.ErrorAs(err, &agentError)
+	assert.Equal("2", agentError.Agent)
+	assert.WithinDuration(time.Now().Add(time.Minute), deadline, 10*time.Second)
+}
+
+func TestConfigureContextCancelled(t *testing.T) {
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assertions.Nil(t, err)
+	for _, workers := range []int{1, 2} {
+		assert := assertions.New(t)
+		now := time.Now()
+		ctx, cancel := context.WithCancel(context.Background())
+		agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+		agent2 := NewAgentContext("2", func(ctx context.Context, r io.Reader, format string) error {
+			cancel()
+			return nil
+		})
+		agent1.Require(agent2)
+		registry, err := NewModuleRegistry([]Agent{agent1})
+		assert.Nil(err)
+		configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithParallelism(workers))
+
+		err = configurator.ConfigureContext(ctx)
+		assert.ErrorIs(err, context.Canceled)
+		assert.False(agent1.isConfigured(now))
+	}
+}
+
+func TestConfigureContextCancelledBeforeStart(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	called := false
+	agent1 := NewAgent("1", func(r io.Reader, format string) error {
+		called = true
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = configurator.ConfigureContext(ctx)
+	assert.Equal(context.Canceled, err)
+	assert.False(called)
+}
@@ -0,0 +1,224 @@
+package configurator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/hashicorp/hcl"
+	"gopkg.in/yaml.v3"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Decoder turns a raw configuration document into a tree of maps, slices and scalars.
+type Decoder interface {
+	Decode(raw []byte) (map[string]any, error)
+}
+
+type DecoderFunc func(raw []byte) (map[string]any, error)
+
+func (f DecoderFunc) Decode(raw []byte) (map[string]any, error) {
+	return f(raw)
+}
+
+// Encoder may be implemented by a Decoder to write documents back. It is needed when
+// agents created with NewAgent are given a document merged from several sources.
+type Encoder interface {
+	Encode(document map[string]any) ([]byte, error)
+}
+
+type codec struct {
+	decode func(raw []byte) (map[string]any, error)
+	encode func(document map[string]any) ([]byte, error)
+}
+
+func (c codec) Decode(raw []byte) (map[string]any, error) {
+	return c.decode(raw)
+}
+
+func (c codec) Encode(document map[string]any) ([]byte, error) {
+	return c.encode(document)
+}
+
+var decoders = struct {
+	sync.RWMutex
+	formats    map[string]Decoder
+	extensions map[string]string
+}{
+	formats:    make(map[string]Decoder),
+	extensions: make(map[string]string),
+}
+
+func init() {
+	RegisterDecoder("yaml", codec{decodeYaml, encodeYaml}, ".yaml", ".yml")
+	RegisterDecoder("yml", codec{decodeYaml, encodeYaml})
+	RegisterDecoder("json", codec{decodeJson, encodeJson}, ".json")
+	RegisterDecoder("toml", codec{decodeToml, encodeToml}, ".toml")
+	RegisterDecoder("ini", codec{decodeIni, encodeIni}, ".ini")
+	RegisterDecoder("dotenv", codec{decodeDotenv, encodeDotenv}, ".env")
+	RegisterDecoder("hcl", DecoderFunc(decodeHcl), ".hcl")
+}
+
+// RegisterDecoder makes decoder available under the format name. Files with one of the
+// extensions are decoded by it when no format is given explicitly.
+func RegisterDecoder(format string, decoder Decoder, extensions ...string) {
+	decoders.Lock()
+	defer decoders.Unlock()
+	format = strings.ToLower(format)
+	decoders.formats[format] = decoder
+	for _, extension := range extensions {
+		decoders.extensions[strings.ToLower(extension)] = format
+	}
+}
+
+// LookupDecoder returns the decoder registered for the format.
+func LookupDecoder(format string) (Decoder, error) {
+	decoders.RLock()
+	defer decoders.RUnlock()
+	decoder, ok := decoders.formats[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf("%w %v", ErrUnknownFormat, format)
+	}
+	return decoder, nil
+}
+
+func detectFormat(path string) (string, error) {
+	decoders.RLock()
+	defer decoders.RUnlock()
+	extension := strings.ToLower(filepath.Ext(path))
+	format, ok := decoders.extensions[extension]
+	if !ok {
+		return "", fmt.Errorf("%w of %v", ErrUnknownFormat, path)
+	}
+	return format, nil
+}
+
+func decodeDocument(raw []byte, format string) (map[string]any, error) {
+	decoder, err := LookupDecoder(format)
+	if err != nil {
+		return nil, err
+	}
+	document, err := decoder.Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	return normalize(document).(map[string]any), nil
+}
+
+func encodeDocument(document map[string]any, format string) ([]byte, error) {
+	decoder, err := LookupDecoder(format)
+	if err != nil {
+		return nil, err
+	}
+	encoder, ok := decoder.(Encoder)
+	if !ok {
+		return nil, fmt.Errorf("configuration format %v does not support encoding", format)
+	}
+	return encoder.Encode(document)
+}
+
+// normalize converts the decoded values so that every mapping is map[string]any and every
+// sequence is []any.
+func normalize(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, item := range v {
+			result[key] = normalize(item)
+		}
+		return result
+	case map[any]any:
+		result := make(map[string]any, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = normalize(item)
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			result[i] = normalize(item)
+		}
+		return result
+	case []map[string]any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			result[i] = normalize(item)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+func decodeYaml(raw []byte) (map[string]any, error) {
+	document := make(map[string]any)
+	if err := yaml.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func encodeYaml(document map[string]any) ([]byte, error) {
+	return yaml.Marshal(document)
+}
+
+func decodeJson(raw []byte) (map[string]any, error) {
+	document := make(map[string]any)
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func encodeJson(document map[string]any) ([]byte, error) {
+	return json.MarshalIndent(document, "", "  ")
+}
+
+func decodeToml(raw []byte) (map[string]any, error) {
+	document := make(map[string]any)
+	if err := toml.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func encodeToml(document map[string]any) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	if err := toml.NewEncoder(&buffer).Encode(document); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeHcl(raw []byte) (map[string]any, error) {
+	document := make(map[string]any)
+	if err := hcl.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	return flattenHclBlocks(document).(map[string]any), nil
+}
+
+// flattenHclBlocks replaces the single element lists hcl produces for every block with
+// the block itself.
+func flattenHclBlocks(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = flattenHclBlocks(item)
+		}
+		return v
+	case []map[string]any:
+		if len(v) == 1 {
+			return flattenHclBlocks(v[0])
+		}
+		result := make([]any, len(v))
+		for i, item := range v {
+			result[i] = flattenHclBlocks(item)
+		}
+		return result
+	default:
+		return value
+	}
+}
@@ -0,0 +1,63 @@
+package configurator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// decodeDotenv reads KEY=value lines into a flat document. Double quoted values support
+// escape sequences, single quoted values are taken literally.
+func decodeDotenv(raw []byte) (map[string]any, error) {
+	document := make(map[string]any)
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		index := strings.IndexByte(line, '=')
+		if index <= 0 {
+			return nil, fmt.Errorf("dotenv: line %v: expected KEY=value", number)
+		}
+		key := strings.TrimSpace(line[:index])
+		value := strings.TrimSpace(line[index+1:])
+		switch {
+		case strings.HasPrefix(value, `"`):
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("dotenv: line %v: %w", number, err)
+			}
+			value = unquoted
+		case strings.HasPrefix(value, "'"):
+			if len(value) < 2 || !strings.HasSuffix(value, "'") {
+				return nil, fmt.Errorf("dotenv: line %v: unterminated quote", number)
+			}
+			value = value[1 : len(value)-1]
+		default:
+			if index := strings.Index(value, " #"); index >= 0 {
+				value = strings.TrimSpace(value[:index])
+			}
+		}
+		document[key] = value
+	}
+	return document, scanner.Err()
+}
+
+func encodeDotenv(document map[string]any) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	for _, key := range sortedKeys(document) {
+		switch value := document[key].(type) {
+		case map[string]any, []any:
+			return nil, fmt.Errorf("dotenv: value of %v can not be written as a scalar", key)
+		case string:
+			fmt.Fprintf(&buffer, "%v=%v\n", key, strconv.Quote(value))
+		default:
+			fmt.Fprintf(&buffer, "%v=%v\n", key, value)
+		}
+	}
+	return buffer.Bytes(), nil
+}
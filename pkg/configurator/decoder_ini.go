@@ -0,0 +1,84 @@
+package configurator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// decodeIni reads key = value pairs; keys below a [section] header are nested into it.
+func decodeIni(raw []byte) (map[string]any, error) {
+	document := make(map[string]any)
+	current := document
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("ini: line %v: unterminated section header", number)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			section, ok := document[name].(map[string]any)
+			if !ok {
+				section = make(map[string]any)
+				document[name] = section
+			}
+			current = section
+			continue
+		}
+		index := strings.IndexAny(line, "=:")
+		if index <= 0 {
+			return nil, fmt.Errorf("ini: line %v: expected key = value", number)
+		}
+		key := strings.TrimSpace(line[:index])
+		current[key] = unquote(strings.TrimSpace(line[index+1:]))
+	}
+	return document, scanner.Err()
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// encodeIni writes top level scalars first and every nested map as a section.
+func encodeIni(document map[string]any) ([]byte, error) {
+	buffer := bytes.Buffer{}
+	keys := sortedKeys(document)
+	for _, key := range keys {
+		if _, ok := document[key].(map[string]any); ok {
+			continue
+		}
+		if err := writeIniValue(&buffer, key, document[key]); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range keys {
+		section, ok := document[key].(map[string]any)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&buffer, "\n[%v]\n", key)
+		for _, name := range sortedKeys(section) {
+			if err := writeIniValue(&buffer, name, section[name]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+func writeIniValue(buffer *bytes.Buffer, key string, value any) error {
+	switch value.(type) {
+	case map[string]any, []any:
+		return fmt.Errorf("ini: value of %v can not be written as a scalar", key)
+	}
+	fmt.Fprintf(buffer, "%v = %v\n", key, value)
+	return nil
+}
@@ -0,0 +1,147 @@
+package configurator
+
+import (
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	assert := assertions.New(t)
+	formats := map[string]string{
+		"test.config.yaml": "yaml",
+		"test.config.YML":  "yaml",
+		"test.config.json": "json",
+		"test.config.toml": "toml",
+		"test.config.ini":  "ini",
+		".env":             "dotenv",
+		"test.config.hcl":  "hcl",
+	}
+	for path, expected := range formats {
+		format, err := detectFormat(path)
+		assert.Nil(err)
+		assert.Equal(expected, format, path)
+	}
+	_, err := detectFormat("test.config")
+	assert.NotNil(err)
+}
+
+func TestDecodeBuiltinFormats(t *testing.T) {
+	assert := assertions.New(t)
+	for _, name := range []string{"test.config.yaml", "test.config.json", "test.config.toml", "test.config.hcl"} {
+		path, err := filepath.Abs("../../test/configurator/" + name)
+		assert.Nil(err)
+		config := module1Config{}
+		agent1 := NewTypedAgent("1", "module1", func(c module1Config) error {
+			config = c
+			return nil
+		})
+		registry, err := NewModuleRegistry([]Agent{agent1})
+		assert.Nil(err)
+		configurator := NewLocalConfigurator(registry, []string{path}, "")
+
+		err = configurator.Configure()
+		assert.Nil(err, name)
+		assert.Equal(module1Config{Param: "value", Port: 8080, Hosts: []string{"alpha", "beta"}}, config, name)
+	}
+}
+
+func TestDecodeIni(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.ini")
+	assert.Nil(err)
+	config := module1Config{}
+	agent1 := NewTypedAgent("1", "module1", func(c module1Config) error {
+		config = c
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "")
+
+	err = configurator.Configure()
+	assert.Nil(err)
+	assert.Equal(module1Config{Param: "value", Port: 8080}, config)
+}
+
+func TestDecodeDotenv(t *testing.T) {
+	assert := assertions.New(t)
+	document, err := decodeDocument([]byte("# comment\nAPP_TAG=1\nexport PARAM=\"a\\tb\"\nPORT='8080' \nHOST=localhost # inline\n"), "dotenv")
+	assert.Nil(err)
+	assert.Equal(map[string]any{"APP_TAG": "1", "PARAM": "a\tb", "PORT": "8080", "HOST": "localhost"}, document)
+
+	_, err = decodeDocument([]byte("PORT\n"), "dotenv")
+	assert.NotNil(err)
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	assert := assertions.New(t)
+	RegisterDecoder("Custom", DecoderFunc(func(raw []byte) (map[string]any, error) {
+		return map[string]any{"raw": string(raw)}, nil
+	}), ".custom")
+	format, err := detectFormat("config.custom")
+	assert.Nil(err)
+	assert.Equal("custom", format)
+	document, err := decodeDocument([]byte("hello"), "CUSTOM")
+	assert.Nil(err)
+	assert.Equal(map[string]any{"raw": "hello"}, document)
+}
+
+func TestConfigureUnknownFormat(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	called := false
+	agent1 := NewAgent("1", func(r io.Reader, format string) error {
+		called = true
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "xml")
+
+	err = configurator.Configure()
+	assert.NotNil(err)
+	assert.False(called)
+}
+
+func TestConfigureDetectedFormat(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.toml")
+	assert.Nil(err)
+	agent1Format := ""
+	agent1 := NewAgent("1", func(r io.Reader, format string) error {
+		agent1Format = format
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "")
+
+	err = configurator.Configure()
+	assert.Nil(err)
+	assert.Equal("toml", agent1Format)
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	assert := assertions.New(t)
+	documents := map[string]map[string]any{
+		"yaml":   {"app": map[string]any{"tag": 1, "hosts": []any{"alpha"}}},
+		"json":   {"app": map[string]any{"tag": float64(1), "hosts": []any{"alpha"}}},
+		"toml":   {"app": map[string]any{"tag": int64(1), "hosts": []any{"alpha"}}},
+		"ini":    {"name": "test", "app": map[string]any{"tag": "1"}},
+		"dotenv": {"APP_TAG": "1", "PARAM": "a b"},
+	}
+	for format, document := range documents {
+		raw, err := encodeDocument(document, format)
+		assert.Nil(err, format)
+		decoded, err := decodeDocument(raw, format)
+		assert.Nil(err, format)
+		assert.Equal(document, decoded, format)
+	}
+	_, err := encodeDocument(map[string]any{"app": map[string]any{"tag": 1}}, "hcl")
+	assert.NotNil(err)
+	_, err = encodeDocument(map[string]any{"app": map[string]any{"tag": 1}}, "dotenv")
+	assert.NotNil(err)
+}
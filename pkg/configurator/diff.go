@@ -0,0 +1,158 @@
+package configurator
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Diff lists the paths which differ between two versions of a section. Paths are relative
+// to the section and written like the paths of a Violation, the section itself has the
+// empty path. A map key or list element present on one side only is reported without the
+// paths below it.
+type Diff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// Empty tells whether the versions are equal.
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Touches tells whether the value at path, or anything below or above it, differs.
+func (d Diff) Touches(path string) bool {
+	for _, list := range [][]string{d.Added, d.Removed, d.Changed} {
+		for _, changed := range list {
+			if related(changed, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func related(x, y string) bool {
+	if len(x) > len(y) {
+		x, y = y, x
+	}
+	if x == "" || x == y {
+		return true
+	}
+	return strings.HasPrefix(y, x) && (y[len(x)] == '.' || y[len(x)] == '[')
+}
+
+// NewDiffAgent works like NewTypedAgent, but updateCallback also receives the value the
+// agent was last configured with and the Diff between both sections. On the first update
+// previous is the zero value and every key of the section counts as added.
+func NewDiffAgent[T any](name, section string, updateCallback func(previous, current T, diff Diff) error, options ...AgentOption) Agent {
+	agent := NewAgent(name, nil, append([]AgentOption{WithSection(section)}, options...)...).(*agentImpl)
+	var mutex sync.Mutex
+	var applied, prepared any
+	configured, pending := false, false
+	agent.apply = func(ctx context.Context, s *snapshot) error {
+		node, err := s.section(section)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		last, ok := applied, configured
+		pending = false
+		mutex.Unlock()
+		var previous, current T
+		if ok {
+			if err := convert(last, &previous); err != nil {
+				return err
+			}
+		}
+		if err := convert(node, &current); err != nil {
+			return err
+		}
+		if err := updateCallback(previous, current, diffSections(last, node, ok)); err != nil {
+			return err
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		prepared, pending = node, true
+		return nil
+	}
+	// the section becomes the previous one only once the update is committed
+	agent.onConfigured = func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if pending {
+			applied, configured, pending = prepared, true, false
+		}
+	}
+	agent.onRolledBack = func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		pending = false
+	}
+	return agent
+}
+
+func diffSections(previous, current any, hasPrevious bool) Diff {
+	diff := Diff{}
+	if !hasPrevious {
+		switch current.(type) {
+		case map[string]any:
+			previous = map[string]any{}
+		case []any:
+			previous = []any{}
+		default:
+			diff.Added = append(diff.Added, "")
+			return diff
+		}
+	}
+	diff.compare("", previous, current)
+	return diff
+}
+
+func (d *Diff) compare(path string, previous, current any) {
+	previousMap, previousIsMap := previous.(map[string]any)
+	currentMap, currentIsMap := current.(map[string]any)
+	if previousIsMap && currentIsMap {
+		keys := sortedKeys(previousMap)
+		for _, key := range sortedKeys(currentMap) {
+			if _, ok := previousMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		for _, key := range keys {
+			previousItem, inPrevious := previousMap[key]
+			currentItem, inCurrent := currentMap[key]
+			switch {
+			case !inCurrent:
+				d.Removed = append(d.Removed, joinPath(path, key))
+			case !inPrevious:
+				d.Added = append(d.Added, joinPath(path, key))
+			default:
+				d.compare(joinPath(path, key), previousItem, currentItem)
+			}
+		}
+		return
+	}
+	previousList, previousIsList := previous.([]any)
+	currentList, currentIsList := current.([]any)
+	if previousIsList && currentIsList {
+		for i := 0; i < max(len(previousList), len(currentList)); i++ {
+			itemPath := fmt.Sprintf("%v[%v]", path, i)
+			switch {
+			case i >= len(currentList):
+				d.Removed = append(d.Removed, itemPath)
+			case i >= len(previousList):
+				d.Added = append(d.Added, itemPath)
+			default:
+				d.compare(itemPath, previousList[i], currentList[i])
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(previous, current) {
+		d.Changed = append(d.Changed, path)
+	}
+}
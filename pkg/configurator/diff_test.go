@@ -0,0 +1,152 @@
+package configurator
+
+import (
+	"errors"
+	"fmt"
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffSections(t *testing.T) {
+	assert := assertions.New(t)
+	previous := map[string]any{
+		"port":  80,
+		"hosts": []any{"alpha", "beta"},
+		"tls":   map[string]any{"cert": "a.pem", "key": "a.key"},
+		"debug": true,
+	}
+	current := map[string]any{
+		"port":    8080,
+		"hosts":   []any{"alpha", "gamma", "delta"},
+		"tls":     map[string]any{"cert": "b.pem", "key": "a.key"},
+		"timeout": "5s",
+	}
+	assert.Equal(Diff{
+		Added:   []string{"hosts[2]", "timeout"},
+		Removed: []string{"debug"},
+		Changed: []string{"hosts[1]", "port", "tls.cert"},
+	}, diffSections(previous, current, true))
+	assert.True(diffSections(previous, previous, true).Empty())
+	assert.Equal(Diff{Added: []string{"debug", "hosts", "port", "tls"}}, diffSections(nil, previous, false))
+	assert.Equal(Diff{Added: []string{""}}, diffSections(nil, 1, false))
+	assert.Equal(Diff{Changed: []string{""}}, diffSections(1, "1", true))
+	assert.Equal(Diff{Changed: []string{"tls"}}, diffSections(map[string]any{"tls": true}, map[string]any{"tls": map[string]any{}}, true))
+}
+
+func TestDiffTouches(t *testing.T) {
+	assert := assertions.New(t)
+	diff := Diff{Added: []string{"hosts[2]"}, Changed: []string{"tls.cert"}}
+	assert.True(diff.Touches("tls"))
+	assert.True(diff.Touches("tls.cert"))
+	assert.True(diff.Touches("hosts"))
+	assert.True(diff.Touches(""))
+	assert.False(diff.Touches("tls.key"))
+	assert.False(diff.Touches("tl"))
+	assert.False(diff.Touches("port"))
+}
+
+type httpConfig struct {
+	Port  int      `yaml:"port"`
+	Hosts []string `yaml:"hosts"`
+}
+
+func TestDiffAgent(t *testing.T) {
+	assert := assertions.New(t)
+	path := filepath.Join(t.TempDir(), "test.config.yaml")
+	assert.Nil(os.WriteFile(path, []byte("http:\n  port: 80\n  hosts: [alpha]\n"), 0640))
+	type update struct {
+		previous, current httpConfig
+		diff              Diff
+	}
+	updates := make([]update, 0)
+	someError := errors.New("some error")
+	fail := false
+	agent1 := NewDiffAgent("1", "http", func(previous, current httpConfig, diff Diff) error {
+		if fail {
+			return someError
+		}
+		updates = append(updates, update{previous, current, diff})
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+	assert.Nil(configurator.Configure())
+	assert.Nil(os.WriteFile(path, []byte("http:\n  port: 8080\n  hosts: [alpha, beta]\n"), 0640))
+	assert.Nil(configurator.Configure())
+	assert.Equal([]update{
+		{
+			current: httpConfig{Port: 80, Hosts: []string{"alpha"}},
+			diff:    Diff{Added: []string{"hosts", "port"}},
+		},
+		{
+			previous: httpConfig{Port: 80, Hosts: []string{"alpha"}},
+			current:  httpConfig{Port: 8080, Hosts: []string{"alpha", "beta"}},
+			diff:     Diff{Added: []string{"hosts[1]"}, Changed: []string{"port"}},
+		},
+	}, updates)
+
+	// a failed update does not become the previous value
+	assert.Nil(os.WriteFile(path, []byte("http:\n  port: 9090\n  hosts: [alpha, beta]\n"), 0640))
+	fail = true
+	assert.ErrorIs(configurator.Configure(), someError)
+	fail = false
+	assert.Nil(configurator.Configure())
+	assert.Equal(update{
+		previous: httpConfig{Port: 8080, Hosts: []string{"alpha", "beta"}},
+		current:  httpConfig{Port: 9090, Hosts: []string{"alpha", "beta"}},
+		diff:     Diff{Changed: []string{"port"}},
+	}, updates[2])
+}
+
+func TestDiffAgentSectionNotFound(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	agent1 := NewDiffAgent("1", "missing", func(previous, current httpConfig, diff Diff) error { return nil })
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+	assert.ErrorIs(configurator.Configure(), ErrSectionNotFound)
+}
+
+func TestDiffAgentRolledBack(t *testing.T) {
+	assert := assertions.New(t)
+	path := filepath.Join(t.TempDir(), "test.config.yaml")
+	write := func(port int) {
+		assert.Nil(os.WriteFile(path, []byte(fmt.Sprintf("http:\n  port: %v\nother: %v\n", port, port)), 0640))
+	}
+	previous := make([]int, 0)
+	agent1 := NewDiffAgent("1", "http", func(p, c httpConfig, diff Diff) error {
+		previous = append(previous, p.Port)
+		return nil
+	})
+	someError := errors.New("some error")
+	fail := false
+	agent2 := NewAgent("2", func(r io.Reader, format string) error {
+		if fail {
+			return someError
+		}
+		return nil
+	}, WithSection("other"))
+	registry, err := NewModuleRegistry([]Agent{agent1, agent2})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithTransactional(), WithChangeDetection())
+
+	write(1)
+	assert.Nil(configurator.Configure())
+	write(2)
+	fail = true
+	assert.ErrorIs(configurator.Configure(), someError)
+	// back to the committed section, which is found unchanged
+	write(1)
+	fail = false
+	assert.Nil(configurator.Configure())
+	write(3)
+	assert.Nil(configurator.Configure())
+	assert.Equal([]int{0, 1, 1}, previous)
+}
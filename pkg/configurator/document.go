@@ -0,0 +1,230 @@
+package configurator
+
+import (
+	"bytes"
+	"gopkg.in/yaml.v3"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// snapshot is the configuration handed to the agents. The document is decoded from raw on
+// first use unless it is given already. Once the document is changed by merging, sources or
+// placeholders, raw is encoded from it on first use instead.
+type snapshot struct {
+	raw       []byte
+	format    string
+	once      sync.Once
+	document  map[string]any
+	err       error
+	stale     bool
+	encoding  sync.Once
+	encodeErr error
+}
+
+func newSnapshot(raw []byte, format string, document map[string]any) *snapshot {
+	return &snapshot{
+		raw:      raw,
+		format:   format,
+		document: document,
+	}
+}
+
+func (s *snapshot) reader() (io.ReadSeeker, error) {
+	s.encoding.Do(func() {
+		if s.stale {
+			s.raw, s.encodeErr = encodeDocument(s.document, s.format)
+		}
+	})
+	if s.encodeErr != nil {
+		return nil, s.encodeErr
+	}
+	return bytes.NewReader(s.raw), nil
+}
+
+func (s *snapshot) tree() (map[string]any, error) {
+	s.once.Do(func() {
+		if s.document == nil {
+			s.document, s.err = decodeDocument(s.raw, s.format)
+		}
+	})
+	return s.document, s.err
+}
+
+// lookupSection returns the subtree addressed by a dot separated path, the empty path
+// addresses the whole document.
+func lookupSection(document map[string]any, section string) (any, bool) {
+	var node any = document
+	if section == "" {
+		return node, true
+	}
+	for _, key := range strings.Split(section, ".") {
+		branch, ok := node.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if node, ok = branch[key]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// setPath stores the value under the path of keys, creating the missing maps on the way.
+func setPath(document map[string]any, path []string, value any) {
+	branch := document
+	for _, key := range path[:len(path)-1] {
+		next, ok := branch[key].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			branch[key] = next
+		}
+		branch = next
+	}
+	branch[path[len(path)-1]] = value
+}
+
+// parseValue interprets a textual value the way a plain yaml scalar is read, so numbers and
+// booleans get their type and [a, b] becomes a list. Values whose type would not give back
+// the same text, like 01234 or 1.10, stay strings, convert still resolves them for numeric
+// and boolean fields.
+func parseValue(text string) any {
+	var value any
+	if err := yaml.Unmarshal([]byte(text), &value); err != nil {
+		return text
+	}
+	switch v := value.(type) {
+	case bool:
+		if strconv.FormatBool(v) == text {
+			return v
+		}
+	case int:
+		if strconv.Itoa(v) == text {
+			return v
+		}
+	case float64:
+		if strconv.FormatFloat(v, 'f', -1, 64) == text {
+			return v
+		}
+	case []any:
+		items := make([]string, 0, len(v))
+		if err := yaml.Unmarshal([]byte(text), &items); err != nil {
+			return normalize(v)
+		}
+		list := make([]any, len(items))
+		for i, item := range items {
+			list[i] = parseValue(item)
+		}
+		return list
+	}
+	return text
+}
+
+// convert copies a decoded subtree into out, which is populated according to its yaml tags.
+// Strings meant for numeric and boolean fields are resolved like plain yaml scalars, so
+// values of formats which only know strings (ini, dotenv) still fill them. Strings meant
+// for any other field are kept as they are.
+func convert(value any, out any) error {
+	node := &yaml.Node{}
+	if err := node.Encode(value); err != nil {
+		return err
+	}
+	untagStrings(node, reflect.TypeOf(out))
+	return node.Decode(out)
+}
+
+var unmarshalerType = reflect.TypeFor[yaml.Unmarshaler]()
+
+// untagStrings drops the string tag of the scalars of node decoded into a numeric or
+// boolean value of type t.
+func untagStrings(node *yaml.Node, t reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			untagStrings(child, t)
+		}
+	case yaml.ScalarNode:
+		switch t.Kind() {
+		case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			if node.Tag == "!!str" {
+				node.Tag = ""
+				node.Style = 0
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for _, child := range node.Content {
+				untagStrings(child, t.Elem())
+			}
+		}
+	case yaml.MappingNode:
+		fields := make(map[string]reflect.Type)
+		switch t.Kind() {
+		case reflect.Map:
+		case reflect.Struct:
+			for _, field := range yamlFields(t) {
+				fields[field.key] = field.Type
+			}
+		default:
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if t.Kind() == reflect.Map {
+				untagStrings(node.Content[i+1], t.Elem())
+			} else if field, ok := fields[node.Content[i].Value]; ok {
+				untagStrings(node.Content[i+1], field)
+			}
+		}
+	}
+}
+
+// yamlField is a field of a struct along with its key, as named by its yaml tags.
+type yamlField struct {
+	key string
+	reflect.StructField
+}
+
+// yamlFields lists the fields of a struct read by yaml in the order they are declared, the
+// fields of inline structs take the place of the struct.
+func yamlFields(t reflect.Type) []yamlField {
+	fields := make([]yamlField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("yaml")
+		if !field.IsExported() && !field.Anonymous || tag == "-" {
+			continue
+		}
+		key, flags, _ := strings.Cut(tag, ",")
+		if slices.Contains(strings.Split(flags, ","), "inline") {
+			if field.Type.Kind() == reflect.Struct {
+				fields = append(fields, yamlFields(field.Type)...)
+			}
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+		fields = append(fields, yamlField{key: key, StructField: field})
+	}
+	return fields
+}
+
+func sortedKeys(document map[string]any) []string {
+	keys := make([]string, 0, len(document))
+	for key := range document {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,202 @@
+package configurator
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoConfigPaths is returned by Configure when the configurator has no paths at all.
+	ErrNoConfigPaths = errors.New("configuration file paths is empty")
+	// ErrNoConfigFile matches a *NoConfigFileError.
+	ErrNoConfigFile = errors.New("no config files found")
+	// ErrDuplicateAgent matches a *DuplicateAgentError.
+	ErrDuplicateAgent = errors.New("found different agents with same name")
+	// ErrCycle matches a *CycleError.
+	ErrCycle = errors.New("dependency cycle detected")
+	// ErrUnknownFormat is wrapped by the errors about formats without a registered decoder.
+	ErrUnknownFormat = errors.New("unknown configuration format")
+	// ErrSectionNotFound is wrapped when the section of a typed agent is missing.
+	ErrSectionNotFound = errors.New("section not found")
+	// ErrUnknownAgent is wrapped when a registry is asked about an agent it does not hold.
+	ErrUnknownAgent = errors.New("unknown agent")
+	// ErrHasDependents matches a *DependentsError.
+	ErrHasDependents = errors.New("agent is required by other agents")
+	// ErrUnknownSecretScheme is wrapped when a placeholder refers to a scheme without a
+	// SecretResolver.
+	ErrUnknownSecretScheme = errors.New("unknown secret scheme")
+	// ErrReferenceNotFound is wrapped when a ${path} reference without a default refers to a
+	// missing value.
+	ErrReferenceNotFound = errors.New("referenced value not found")
+	// ErrReferenceCycle is wrapped when ${path} references lead back to themselves.
+	ErrReferenceCycle = errors.New("reference cycle detected")
+)
+
+// NoConfigFileError is returned by Configure when none of the paths could be read. Causes
+// holds the error of every path in Tried.
+type NoConfigFileError struct {
+	Tried  []string
+	Causes []error
+}
+
+func (e *NoConfigFileError) Error() string {
+	builder := strings.Builder{}
+	builder.WriteString(ErrNoConfigFile.Error())
+	for _, cause := range e.Causes {
+		fmt.Fprintf(&builder, "\n  %v", cause)
+	}
+	return builder.String()
+}
+
+func (e *NoConfigFileError) Is(target error) bool {
+	return target == ErrNoConfigFile
+}
+
+func (e *NoConfigFileError) Unwrap() []error {
+	return e.Causes
+}
+
+// DuplicateAgentError is returned when two different agents are registered under one name.
+type DuplicateAgentError struct {
+	Name string
+}
+
+func (e *DuplicateAgentError) Error() string {
+	return fmt.Sprintf("%v %v", ErrDuplicateAgent, e.Name)
+}
+
+func (e *DuplicateAgentError) Is(target error) bool {
+	return target == ErrDuplicateAgent
+}
+
+// CycleError is returned when agents require each other. Path starts and ends with the
+// same agent, it has two elements when an agent requires itself.
+type CycleError struct {
+	Path []string
+}
+
+func (e *CycleError) Error() string {
+	if len(e.Path) == 2 {
+		return fmt.Sprintf("agent %v requires itself", e.Path[0])
+	}
+	return fmt.Sprintf("%v: %v", ErrCycle, strings.Join(e.Path, " -> "))
+}
+
+func (e *CycleError) Is(target error) bool {
+	return target == ErrCycle
+}
+
+// DependentsError is returned when an agent required by registered agents is unregistered.
+type DependentsError struct {
+	Agent      string
+	Dependents []string
+}
+
+func (e *DependentsError) Error() string {
+	return fmt.Sprintf("agent %v is required by %v", e.Agent, strings.Join(e.Dependents, ", "))
+}
+
+func (e *DependentsError) Is(target error) bool {
+	return target == ErrHasDependents
+}
+
+// TimeoutError is returned for an agent whose update callback exceeded its timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v", e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// AgentError is an error returned by the update of an agent.
+type AgentError struct {
+	Agent string
+	Err   error
+}
+
+func (e *AgentError) Error() string {
+	return fmt.Sprintf("agent %v: %v", e.Agent, e.Err)
+}
+
+func (e *AgentError) Unwrap() error {
+	return e.Err
+}
+
+// AgentFailure describes an agent which failed to update and the agents skipped because
+// they depend on it.
+type AgentFailure struct {
+	Agent   string
+	Err     error
+	Skipped []string
+}
+
+// ConfigureError reports every failure of a Configure run made with WithContinueOnError.
+type ConfigureError struct {
+	Failures []AgentFailure
+}
+
+func (e *ConfigureError) Error() string {
+	builder := strings.Builder{}
+	builder.WriteString("configuration failed:")
+	for _, failure := range e.Failures {
+		fmt.Fprintf(&builder, "\n  %v: %v", failure.Agent, failure.Err)
+		if len(failure.Skipped) > 0 {
+			fmt.Fprintf(&builder, " (skipped %v)", strings.Join(failure.Skipped, ", "))
+		}
+	}
+	return builder.String()
+}
+
+// Unwrap returns an *AgentError for every failure.
+func (e *ConfigureError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, failure := range e.Failures {
+		errs[i] = &AgentError{Agent: failure.Agent, Err: failure.Err}
+	}
+	return errs
+}
+
+// newConfigureError lists the failures in the order of agents along with their direct and
+// indirect dependents.
+func newConfigureError(agents []Agent, failures map[string]error) *ConfigureError {
+	graph := newDependencyGraph(agents)
+	position := make(map[string]int, len(agents))
+	for i, agent := range agents {
+		position[agent.moduleName()] = i
+	}
+	result := &ConfigureError{}
+	for _, agent := range agents {
+		err, failed := failures[agent.moduleName()]
+		if !failed {
+			continue
+		}
+		skipped := make([]string, 0)
+		visited := make(map[string]bool)
+		queue := []Agent{agent}
+		for len(queue) > 0 {
+			for _, parent := range graph.dependents[queue[0].moduleName()] {
+				if !visited[parent.moduleName()] {
+					visited[parent.moduleName()] = true
+					queue = append(queue, parent)
+					skipped = append(skipped, parent.moduleName())
+				}
+			}
+			queue = queue[1:]
+		}
+		slices.SortFunc(skipped, func(x, y string) int {
+			return cmp.Compare(position[x], position[y])
+		})
+		result.Failures = append(result.Failures, AgentFailure{Agent: agent.moduleName(), Err: err, Skipped: skipped})
+	}
+	return result
+}
@@ -0,0 +1,84 @@
+package configurator
+
+import (
+	"errors"
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"testing"
+)
+
+func TestNoConfigFileError(t *testing.T) {
+	assert := assertions.New(t)
+	wrongPath, err := filepath.Abs("../../test/configurator/wrongPath.config.yaml")
+	assert.Nil(err)
+	otherPath, err := filepath.Abs("../../test/configurator/otherPath.config.yaml")
+	assert.Nil(err)
+	registry, err := NewModuleRegistry([]Agent{})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{wrongPath, otherPath}, "yaml")
+
+	err = configurator.Configure()
+	assert.ErrorIs(err, ErrNoConfigFile)
+	assert.ErrorIs(err, fs.ErrNotExist)
+	var noConfigFileError *NoConfigFileError
+	assert.ErrorAs(err, &noConfigFileError)
+	assert.Equal([]string{wrongPath, otherPath}, noConfigFileError.Tried)
+	assert.Len(noConfigFileError.Causes, 2)
+
+	configurator = NewLocalConfigurator(registry, []string{}, "yaml")
+	err = configurator.Configure()
+	assert.Equal(ErrNoConfigPaths, err)
+}
+
+func TestDuplicateAgentError(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+
+	_, err := NewModuleRegistry([]Agent{agent1, agent2})
+	assert.ErrorIs(err, ErrDuplicateAgent)
+	assert.Equal(&DuplicateAgentError{Name: "1"}, err)
+	assert.EqualError(err, "found different agents with same name 1")
+}
+
+func TestCycleError(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	agent2.Require(agent1)
+
+	_, err := NewModuleRegistry([]Agent{agent1})
+	assert.ErrorIs(err, ErrCycle)
+	assert.Equal(&CycleError{Path: []string{"1", "2", "1"}}, err)
+}
+
+func TestUnknownFormatError(t *testing.T) {
+	assert := assertions.New(t)
+	_, err := LookupDecoder("xml")
+	assert.ErrorIs(err, ErrUnknownFormat)
+	assert.EqualError(err, "unknown configuration format xml")
+	_, err = detectFormat("config.xml")
+	assert.ErrorIs(err, ErrUnknownFormat)
+}
+
+func TestAgentErrorInConfigureError(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	someError := errors.New("some error")
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return someError })
+	agent2 := NewTypedAgent("2", "module2", func(c module1Config) error { return nil })
+	registry, err := NewModuleRegistry([]Agent{agent1, agent2})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithContinueOnError())
+
+	err = configurator.Configure()
+	var agentError *AgentError
+	assert.ErrorAs(err, &agentError)
+	assert.Equal("1", agentError.Agent)
+	assert.ErrorIs(err, someError)
+	assert.ErrorIs(err, ErrSectionNotFound)
+}
@@ -0,0 +1,171 @@
+package configurator
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// GraphOption customizes the graphs written by WriteDot and WriteMermaid.
+type GraphOption func(o *graphOptions)
+
+type graphOptions struct {
+	status     bool
+	timeLayout string
+}
+
+// WithStatusAnnotation marks every agent of the graph as configured, failed or pending and
+// adds the time of its last successful update.
+func WithStatusAnnotation() GraphOption {
+	return func(o *graphOptions) {
+		o.status = true
+	}
+}
+
+// WithTimeLayout sets the layout of the times added by WithStatusAnnotation, time.RFC3339
+// by default.
+func WithTimeLayout(layout string) GraphOption {
+	return func(o *graphOptions) {
+		o.timeLayout = layout
+	}
+}
+
+// graphNode is an agent of the graph along with the registered agents it requires or is
+// linked to.
+type graphNode struct {
+	name     string
+	label    string
+	class    string
+	requires []string
+	optional []string
+	after    []string
+}
+
+// graphNodes lists the registered agents sorted by name.
+func graphNodes(registry Registry, options []GraphOption) []graphNode {
+	o := &graphOptions{timeLayout: time.RFC3339}
+	for _, option := range options {
+		option(o)
+	}
+	agents := registry.getAll()
+	nodes := make([]graphNode, len(agents))
+	for i, agent := range agents {
+		node := graphNode{name: agent.moduleName(), label: agent.moduleName()}
+		for _, child := range agent.children() {
+			if registry.get(child.moduleName()) == child {
+				node.requires = append(node.requires, child.moduleName())
+			}
+		}
+		links := agent.links()
+		for _, name := range sortedNames(links) {
+			if registry.get(name) == nil {
+				continue
+			}
+			if links[name] == orderLink {
+				node.after = append(node.after, name)
+			} else {
+				node.optional = append(node.optional, name)
+			}
+		}
+		if o.status {
+			status := agent.status()
+			switch {
+			case status.LastError != nil:
+				node.class = "failed"
+				node.label += "\nfailed: " + status.LastError.Error()
+			case status.Configured:
+				node.class = "configured"
+				node.label += "\nconfigured " + status.ConfiguredAt.Format(o.timeLayout)
+			default:
+				node.class = "pending"
+			}
+		}
+		nodes[i] = node
+	}
+	return nodes
+}
+
+var dotColors = map[string]string{
+	"configured": "green",
+	"failed":     "red",
+	"pending":    "gray",
+}
+
+// writeDot writes the graph in the Graphviz DOT language. An edge leads from an agent to
+// the agent it requires, it is dashed for an optional requirement and dotted for After.
+func writeDot(w io.Writer, registry Registry, options []GraphOption) error {
+	builder := strings.Builder{}
+	builder.WriteString("digraph agents {\n")
+	for _, node := range graphNodes(registry, options) {
+		if node.class == "" {
+			fmt.Fprintf(&builder, "  %v;\n", dotQuote(node.name))
+		} else {
+			fmt.Fprintf(&builder, "  %v [label=%v, color=%v];\n", dotQuote(node.name), dotQuote(node.label), dotColors[node.class])
+		}
+		for _, child := range node.requires {
+			fmt.Fprintf(&builder, "  %v -> %v;\n", dotQuote(node.name), dotQuote(child))
+		}
+		for _, child := range node.optional {
+			fmt.Fprintf(&builder, "  %v -> %v [style=dashed];\n", dotQuote(node.name), dotQuote(child))
+		}
+		for _, child := range node.after {
+			fmt.Fprintf(&builder, "  %v -> %v [style=dotted];\n", dotQuote(node.name), dotQuote(child))
+		}
+	}
+	builder.WriteString("}\n")
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// writeMermaid writes the graph as a Mermaid flowchart. Agents are given the ids n0, n1 and
+// so on since Mermaid ids can not hold arbitrary names. Optional requirements are drawn as
+// dotted edges, After as dotted edges labelled after.
+func writeMermaid(w io.Writer, registry Registry, options []GraphOption) error {
+	nodes := graphNodes(registry, options)
+	ids := make(map[string]string, len(nodes))
+	for i, node := range nodes {
+		ids[node.name] = fmt.Sprintf("n%v", i)
+	}
+	builder := strings.Builder{}
+	builder.WriteString("flowchart TD\n")
+	annotated := false
+	for _, node := range nodes {
+		fmt.Fprintf(&builder, "  %v[%v]", ids[node.name], mermaidQuote(node.label))
+		if node.class != "" {
+			annotated = true
+			fmt.Fprintf(&builder, ":::%v", node.class)
+		}
+		builder.WriteString("\n")
+	}
+	for _, node := range nodes {
+		for _, child := range node.requires {
+			fmt.Fprintf(&builder, "  %v --> %v\n", ids[node.name], ids[child])
+		}
+		for _, child := range node.optional {
+			fmt.Fprintf(&builder, "  %v -.-> %v\n", ids[node.name], ids[child])
+		}
+		for _, child := range node.after {
+			fmt.Fprintf(&builder, "  %v -. after .-> %v\n", ids[node.name], ids[child])
+		}
+	}
+	if annotated {
+		builder.WriteString("  classDef configured stroke:green\n")
+		builder.WriteString("  classDef failed stroke:red\n")
+		builder.WriteString("  classDef pending stroke:gray\n")
+	}
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "\n", "<br>")
+
+func mermaidQuote(s string) string {
+	return `"` + mermaidEscaper.Replace(s) + `"`
+}
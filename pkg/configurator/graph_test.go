@@ -0,0 +1,123 @@
+package configurator
+
+import (
+	"errors"
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newGraphRegistry(t *testing.T) Registry {
+	agent1 := NewAgent("server", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("db", func(r io.Reader, format string) error { return nil })
+	agent3 := NewAgent(`my "cache"`, func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	agent1.Require(agent3)
+	agent2.Require(agent3)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assertions.Nil(t, err)
+	return registry
+}
+
+func TestWriteDot(t *testing.T) {
+	assert := assertions.New(t)
+	registry := newGraphRegistry(t)
+	builder := strings.Builder{}
+	assert.Nil(registry.WriteDot(&builder))
+	assert.Equal(`digraph agents {
+  "db";
+  "db" -> "my \"cache\"";
+  "my \"cache\"";
+  "server";
+  "server" -> "db";
+  "server" -> "my \"cache\"";
+}
+`, builder.String())
+}
+
+func TestWriteMermaid(t *testing.T) {
+	assert := assertions.New(t)
+	registry := newGraphRegistry(t)
+	builder := strings.Builder{}
+	assert.Nil(registry.WriteMermaid(&builder))
+	assert.Equal(`flowchart TD
+  n0["db"]
+  n1["my #quot;cache#quot;"]
+  n2["server"]
+  n0 --> n1
+  n2 --> n0
+  n2 --> n1
+`, builder.String())
+}
+
+func TestWriteGraphWithStatus(t *testing.T) {
+	assert := assertions.New(t)
+	registry := newGraphRegistry(t)
+	configured := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	db, _ := registry.Lookup("db")
+	db.(*agentImpl).time = &configured
+	db.(*agentImpl).configuredEpoch = firstEpoch
+	cache, _ := registry.Lookup(`my "cache"`)
+	cache.(*agentImpl).lastError = errors.New("connection refused")
+
+	builder := strings.Builder{}
+	assert.Nil(registry.WriteDot(&builder, WithStatusAnnotation(), WithTimeLayout(time.DateTime)))
+	assert.Equal(`digraph agents {
+  "db" [label="db\nconfigured 2024-05-01 12:00:00", color=green];
+  "db" -> "my \"cache\"";
+  "my \"cache\"" [label="my \"cache\"\nfailed: connection refused", color=red];
+  "server" [label="server", color=gray];
+  "server" -> "db";
+  "server" -> "my \"cache\"";
+}
+`, builder.String())
+
+	builder.Reset()
+	assert.Nil(registry.WriteMermaid(&builder, WithStatusAnnotation()))
+	assert.Equal(`flowchart TD
+  n0["db<br>configured 2024-05-01T12:00:00Z"]:::configured
+  n1["my #quot;cache#quot;<br>failed: connection refused"]:::failed
+  n2["server"]:::pending
+  n0 --> n1
+  n2 --> n0
+  n2 --> n1
+  classDef configured stroke:green
+  classDef failed stroke:red
+  classDef pending stroke:gray
+`, builder.String())
+}
+
+func TestWriteGraphWithLinks(t *testing.T) {
+	assert := assertions.New(t)
+	server := NewAgent("server", func(r io.Reader, format string) error { return nil })
+	metrics := NewAgent("metrics", func(r io.Reader, format string) error { return nil })
+	tracing := NewAgent("tracing", func(r io.Reader, format string) error { return nil })
+	server.RequireOptional(metrics)
+	server.After(tracing)
+	server.After(NewAgent("unregistered", func(r io.Reader, format string) error { return nil }))
+	registry, err := NewModuleRegistry([]Agent{server, metrics, tracing})
+	assert.Nil(err)
+
+	builder := strings.Builder{}
+	assert.Nil(registry.WriteDot(&builder))
+	assert.Equal(`digraph agents {
+  "metrics";
+  "server";
+  "server" -> "metrics" [style=dashed];
+  "server" -> "tracing" [style=dotted];
+  "tracing";
+}
+`, builder.String())
+
+	builder.Reset()
+	assert.Nil(registry.WriteMermaid(&builder))
+	assert.Equal(`flowchart TD
+  n0["metrics"]
+  n1["server"]
+  n2["tracing"]
+  n1 -.-> n0
+  n1 -. after .-> n2
+`, builder.String())
+}
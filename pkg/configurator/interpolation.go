@@ -0,0 +1,156 @@
+package configurator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// WithInterpolation expands the ${path} references found in the string values of the
+// document to the values at the dot separated path of the same document, before the
+// document is validated and handed to the agents. ${path:-default} gives the default used
+// when the value is missing or empty, $${ is kept as a literal ${. A string made of a
+// single reference takes the referenced value whatever its type, references within longer
+// strings may only refer to scalars. References may lead to values holding references,
+// but not back to themselves. References are expanded along with the secrets, see
+// WithSecrets, so that ${scheme:ref} placeholders are never taken for references.
+func WithInterpolation() Option {
+	return func(c *configuratorImpl) {
+		c.interpolate = true
+	}
+}
+
+// interpolator expands the placeholders of a document. References are resolved against
+// the document as loaded, every referenced string is expanded once.
+type interpolator struct {
+	c        *configuratorImpl
+	original map[string]any
+	expanded map[string]any
+	stack    []string
+}
+
+func newInterpolator(c *configuratorImpl, document map[string]any) *interpolator {
+	return &interpolator{
+		c:        c,
+		original: normalize(document).(map[string]any),
+		expanded: make(map[string]any),
+	}
+}
+
+// expand returns the value of the string text found at path. A map or list it refers to is
+// returned as an expanded copy.
+func (in *interpolator) expand(path, text string) (any, error) {
+	if value, ok := in.expanded[path]; ok {
+		return normalize(value), nil
+	}
+	if i := slices.Index(in.stack, path); i >= 0 {
+		cycle := append(slices.Clone(in.stack[i:]), path)
+		return nil, fmt.Errorf("%w: %v", ErrReferenceCycle, strings.Join(cycle, " -> "))
+	}
+	in.stack = append(in.stack, path)
+	defer func() {
+		in.stack = in.stack[:len(in.stack)-1]
+	}()
+	var value any
+	var err error
+	if content, ok := singlePlaceholder(text); ok && in.isReference(content) {
+		value, err = in.reference(content)
+	} else {
+		value, err = expandPlaceholders(text, in.resolve)
+	}
+	if err != nil {
+		return nil, err
+	}
+	in.expanded[path] = value
+	return normalize(value), nil
+}
+
+// resolve returns the text a placeholder within a string stands for.
+func (in *interpolator) resolve(content string) (string, error) {
+	if scheme, ref, ok := splitScheme(content); ok {
+		if in.c.secrets == nil {
+			return "${" + content + "}", nil
+		}
+		return in.c.resolveSecret(scheme, ref)
+	}
+	if !in.c.interpolate {
+		return "${" + content + "}", nil
+	}
+	value, err := in.reference(content)
+	if err != nil {
+		return "", err
+	}
+	switch value.(type) {
+	case map[string]any:
+		return "", fmt.Errorf("%v refers to a map, which can only be referenced as a whole value", referenceName(content))
+	case []any:
+		return "", fmt.Errorf("%v refers to a list, which can only be referenced as a whole value", referenceName(content))
+	}
+	return fmt.Sprint(value), nil
+}
+
+func (in *interpolator) isReference(content string) bool {
+	_, _, isSecret := splitScheme(content)
+	return in.c.interpolate && !isSecret
+}
+
+// reference returns the value a ${path} or ${path:-default} reference stands for.
+func (in *interpolator) reference(content string) (any, error) {
+	name, fallback, hasDefault := strings.Cut(content, ":-")
+	value, ok := lookupSection(in.original, name)
+	ok = ok && name != ""
+	if text, isString := value.(string); isString && ok {
+		if value, err := in.expand(name, text); err != nil || value != "" || !hasDefault {
+			return value, err
+		}
+	} else if ok && value != nil {
+		return in.expandValue(name, value)
+	}
+	if !hasDefault {
+		return nil, fmt.Errorf("%w %v", ErrReferenceNotFound, name)
+	}
+	return expandPlaceholders(fallback, in.resolve)
+}
+
+// expandValue returns a copy of the value found at path with its strings expanded at their
+// own path, so that a reference leading back to the value is reported as a cycle.
+func (in *interpolator) expandValue(path string, value any) (any, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		expanded := make(map[string]any, len(v))
+		for _, key := range sortedKeys(v) {
+			item, err := in.expandValue(joinPath(path, key), v[key])
+			if err != nil {
+				return nil, err
+			}
+			expanded[key] = item
+		}
+		return expanded, nil
+	case []any:
+		expanded := make([]any, len(v))
+		for i, item := range v {
+			item, err := in.expandValue(fmt.Sprintf("%v[%v]", path, i), item)
+			if err != nil {
+				return nil, err
+			}
+			expanded[i] = item
+		}
+		return expanded, nil
+	case string:
+		return in.expand(path, v)
+	}
+	return value, nil
+}
+
+// singlePlaceholder returns the content of text when text is nothing but one placeholder.
+func singlePlaceholder(text string) (string, bool) {
+	if !strings.HasPrefix(text, "${") || closingBrace(text, 2) != len(text)-1 {
+		return "", false
+	}
+	return text[2 : len(text)-1], true
+}
+
+func referenceName(content string) string {
+	name, _, _ := strings.Cut(content, ":-")
+	return name
+}
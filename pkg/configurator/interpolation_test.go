@@ -0,0 +1,107 @@
+package configurator
+
+import (
+	assertions "github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type serviceConfig struct {
+	Url     string   `yaml:"url"`
+	Port    int      `yaml:"port"`
+	Hosts   []string `yaml:"hosts"`
+	Timeout string   `yaml:"timeout"`
+	Pattern string   `yaml:"pattern"`
+	Token   string   `yaml:"token"`
+}
+
+func configureDocument(t *testing.T, document string, section string, options ...Option) (serviceConfig, error) {
+	path := filepath.Join(t.TempDir(), "test.config.yaml")
+	assertions.Nil(t, os.WriteFile(path, []byte(document), 0640))
+	config := serviceConfig{}
+	agent1 := NewTypedAgent("1", section, func(c serviceConfig) error {
+		config = c
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assertions.Nil(t, err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", options...)
+	return config, configurator.Configure()
+}
+
+func TestConfigureWithInterpolation(t *testing.T) {
+	assert := assertions.New(t)
+	t.Setenv("SERVICE_TOKEN", "t0ken")
+	config, err := configureDocument(t, strings.Join([]string{
+		"defaults:",
+		"  host: db.internal",
+		"  port: 5432",
+		"  hosts: [alpha, beta]",
+		"  url: postgres://${defaults.host}:${defaults.port}",
+		"  empty: ''",
+		"service:",
+		"  url: ${defaults.url}/app",
+		"  port: ${defaults.port}",
+		"  hosts: ${defaults.hosts}",
+		"  timeout: ${defaults.timeout:-${defaults.empty:-5s}}",
+		"  pattern: $${literal}",
+		"  token: ${env:SERVICE_TOKEN}",
+	}, "\n"), "service", WithInterpolation(), WithSecrets())
+	assert.Nil(err)
+	assert.Equal(serviceConfig{
+		Url:     "postgres://db.internal:5432/app",
+		Port:    5432,
+		Hosts:   []string{"alpha", "beta"},
+		Timeout: "5s",
+		Pattern: "${literal}",
+		Token:   "t0ken",
+	}, config)
+}
+
+func TestConfigureWithInterpolationWithoutSecrets(t *testing.T) {
+	assert := assertions.New(t)
+	config, err := configureDocument(t, "service:\n  url: ${service.token}\n  token: ${env:SERVICE_TOKEN}\n", "service", WithInterpolation())
+	assert.Nil(err)
+	assert.Equal(serviceConfig{Url: "${env:SERVICE_TOKEN}", Token: "${env:SERVICE_TOKEN}"}, config)
+}
+
+func TestConfigureWithInterpolationErrors(t *testing.T) {
+	assert := assertions.New(t)
+	_, err := configureDocument(t, strings.Join([]string{
+		"a: ${b}",
+		"b: x${c}",
+		"c: ${a}",
+		"d: ${missing}",
+		"e: ${defaults}",
+		"f: url ${defaults}",
+		"defaults:",
+		"  host: localhost",
+	}, "\n"), "defaults", WithInterpolation())
+	assert.ErrorIs(err, ErrReferenceCycle)
+	assert.ErrorIs(err, ErrReferenceNotFound)
+	assert.Equal(strings.Join([]string{
+		`a: can not resolve "${b}": reference cycle detected: a -> b -> c -> a`,
+		`b: can not resolve "x${c}": reference cycle detected: b -> c -> a -> b`,
+		`c: can not resolve "${a}": reference cycle detected: c -> a -> b -> c`,
+		`d: can not resolve "${missing}": referenced value not found missing`,
+		`f: can not resolve "url ${defaults}": defaults refers to a map, which can only be referenced as a whole value`,
+	}, "\n"), err.Error())
+}
+
+func TestConfigureWithInterpolationMapCycle(t *testing.T) {
+	assert := assertions.New(t)
+	_, err := configureDocument(t, strings.Join([]string{
+		"a: ${b}",
+		"b:",
+		"  x: ${a}",
+		"  y: $${literal}",
+		"c: ${b.y}",
+	}, "\n"), "c", WithInterpolation())
+	assert.ErrorIs(err, ErrReferenceCycle)
+	assert.Equal(strings.Join([]string{
+		`a: can not resolve "${b}": reference cycle detected: a -> b.x -> a`,
+		`b.x: can not resolve "${a}": reference cycle detected: b.x -> a -> b.x`,
+	}, "\n"), err.Error())
+}
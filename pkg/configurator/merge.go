@@ -0,0 +1,41 @@
+package configurator
+
+// MergePolicy defines how a list of an overriding document is combined with the list at
+// the same path of the documents below it.
+type MergePolicy int
+
+const (
+	// ReplaceLists lets the overriding list take the place of the previous one.
+	ReplaceLists MergePolicy = iota
+	// AppendLists appends the items of the overriding list to the previous one.
+	AppendLists
+)
+
+// merge deep merges overlay into base and returns the result, neither argument is
+// modified. Maps are merged key by key, scalars and values of different kinds are
+// overridden and lists are combined according to the policy.
+func merge(base, overlay map[string]any, policy MergePolicy) map[string]any {
+	result := make(map[string]any, len(base)+len(overlay))
+	for key, value := range base {
+		result[key] = value
+	}
+	for key, value := range overlay {
+		result[key] = mergeValue(result[key], value, policy)
+	}
+	return result
+}
+
+func mergeValue(base, overlay any, policy MergePolicy) any {
+	switch o := overlay.(type) {
+	case map[string]any:
+		if b, ok := base.(map[string]any); ok {
+			return merge(b, o, policy)
+		}
+	case []any:
+		if b, ok := base.([]any); ok && policy == AppendLists {
+			list := make([]any, 0, len(b)+len(o))
+			return append(append(list, b...), o...)
+		}
+	}
+	return overlay
+}
@@ -0,0 +1,101 @@
+package configurator
+
+import (
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	assert := assertions.New(t)
+	base := map[string]any{
+		"app": map[string]any{"tag": 1, "name": "base"},
+		"module1": map[string]any{
+			"hosts": []any{"alpha"},
+			"port":  8080,
+		},
+		"debug": false,
+	}
+	overlay := map[string]any{
+		"app":     map[string]any{"tag": 2},
+		"module1": map[string]any{"hosts": []any{"beta"}},
+		"debug":   map[string]any{"level": "info"},
+	}
+	result := merge(base, overlay, ReplaceLists)
+	assert.Equal(map[string]any{
+		"app": map[string]any{"tag": 2, "name": "base"},
+		"module1": map[string]any{
+			"hosts": []any{"beta"},
+			"port":  8080,
+		},
+		"debug": map[string]any{"level": "info"},
+	}, result)
+	assert.Equal(1, base["app"].(map[string]any)["tag"])
+
+	result = merge(base, overlay, AppendLists)
+	assert.Equal([]any{"alpha", "beta"}, result["module1"].(map[string]any)["hosts"])
+	assert.Equal([]any{"alpha"}, base["module1"].(map[string]any)["hosts"])
+}
+
+func TestConfigureMerge(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	directory := t.TempDir()
+	override := filepath.Join(directory, "override.json")
+	assert.Nil(os.WriteFile(override, []byte(`{"module1": {"port": 9090, "hosts": ["gamma"]}}`), 0640))
+	missing := filepath.Join(directory, "missing.yaml")
+
+	config := module1Config{}
+	agent1 := NewTypedAgent("1", "module1", func(c module1Config) error {
+		config = c
+		return nil
+	})
+	raw := ""
+	agent2 := NewAgent("2", func(r io.Reader, format string) error {
+		buffer, ioerr := io.ReadAll(r)
+		raw = string(buffer)
+		assert.Equal("yaml", format)
+		return ioerr
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1, agent2})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path, missing, override}, "", WithMerge(AppendLists))
+
+	err = configurator.Configure()
+	assert.Nil(err)
+	assert.Equal(module1Config{Param: "value", Port: 9090, Hosts: []string{"alpha", "beta", "gamma"}}, config)
+	document, err := decodeDocument([]byte(raw), "yaml")
+	assert.Nil(err)
+	assert.Equal(map[string]any{
+		"app": map[string]any{"tag": 1},
+		"module1": map[string]any{
+			"param": "value",
+			"port":  9090,
+			"hosts": []any{"alpha", "beta", "gamma"},
+		},
+	}, document)
+}
+
+func TestConfigureMergeSingleFileIsNotEncoded(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	expected, err := os.ReadFile(path)
+	assert.Nil(err)
+	raw := ""
+	agent1 := NewAgent("1", func(r io.Reader, format string) error {
+		buffer, ioerr := io.ReadAll(r)
+		raw = string(buffer)
+		return ioerr
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithMerge(ReplaceLists))
+
+	err = configurator.Configure()
+	assert.Nil(err)
+	assert.Equal(string(expected), raw)
+}
@@ -0,0 +1,128 @@
+package configurator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// expandPlaceholders replaces every ${...} placeholder of text by what resolve returns for
+// its content. Placeholders may be nested in the content, $${ stands for a literal ${.
+func expandPlaceholders(text string, resolve func(content string) (string, error)) (string, error) {
+	if !strings.Contains(text, "${") {
+		return text, nil
+	}
+	builder := strings.Builder{}
+	for i := 0; i < len(text); {
+		switch {
+		case strings.HasPrefix(text[i:], "$${"):
+			builder.WriteString("${")
+			i += 3
+		case strings.HasPrefix(text[i:], "${"):
+			end := closingBrace(text, i+2)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated placeholder %v", text[i:])
+			}
+			value, err := resolve(text[i+2 : end])
+			if err != nil {
+				return "", err
+			}
+			builder.WriteString(value)
+			i = end + 1
+		default:
+			builder.WriteByte(text[i])
+			i++
+		}
+	}
+	return builder.String(), nil
+}
+
+// closingBrace returns the index of the brace closing the placeholder whose content starts
+// at start, or -1.
+func closingBrace(text string, start int) int {
+	depth := 1
+	for i := start; i < len(text); i++ {
+		switch {
+		case strings.HasPrefix(text[i:], "${"):
+			depth++
+			i++
+		case text[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitScheme splits the content of a ${scheme:ref} placeholder. A scheme starts with a
+// letter followed by letters, digits, _ or -, and is not followed by a dash, so that
+// ${path:-default} is not taken for one.
+func splitScheme(content string) (scheme, ref string, ok bool) {
+	scheme, ref, found := strings.Cut(content, ":")
+	if !found || scheme == "" || strings.HasPrefix(ref, "-") {
+		return "", "", false
+	}
+	for i, c := range scheme {
+		letter := 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+		if !letter && (i == 0 || !('0' <= c && c <= '9' || c == '_' || c == '-')) {
+			return "", "", false
+		}
+	}
+	return scheme, ref, true
+}
+
+// PlaceholderError is returned by Configure when a placeholder of the document can not be
+// resolved. Path addresses the value holding it, written like the path of a Violation.
+type PlaceholderError struct {
+	Path  string
+	Value string
+	Err   error
+}
+
+func (e *PlaceholderError) Error() string {
+	return fmt.Sprintf("%v: can not resolve %q: %v", e.Path, e.Value, e.Err)
+}
+
+func (e *PlaceholderError) Unwrap() error {
+	return e.Err
+}
+
+// expandDocument replaces the string values of the document by what expand returns for
+// them, maps and lists returned are expected to be expanded already. It reports whether any value changed
+// and a *PlaceholderError for every value which failed to expand.
+func expandDocument(document map[string]any, expand func(path, text string) (any, error)) (bool, error) {
+	changed := false
+	errs := make([]error, 0)
+	var walk func(path string, value any) any
+	walk = func(path string, value any) any {
+		switch v := value.(type) {
+		case map[string]any:
+			for _, key := range sortedKeys(v) {
+				v[key] = walk(joinPath(path, key), v[key])
+			}
+		case []any:
+			for i, item := range v {
+				v[i] = walk(fmt.Sprintf("%v[%v]", path, i), item)
+			}
+		case string:
+			expanded, err := expand(path, v)
+			if err != nil {
+				errs = append(errs, &PlaceholderError{Path: path, Value: v, Err: err})
+				return v
+			}
+			switch e := expanded.(type) {
+			case string:
+				changed = changed || e != v
+				return e
+			default:
+				changed = true
+				return e
+			}
+		}
+		return value
+	}
+	walk("", document)
+	return changed, errors.Join(errs...)
+}
@@ -0,0 +1,75 @@
+package configurator
+
+import (
+	"errors"
+	assertions "github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestExpandPlaceholders(t *testing.T) {
+	assert := assertions.New(t)
+	resolve := func(content string) (string, error) {
+		if content == "fail" {
+			return "", errors.New("failed")
+		}
+		return strings.ToUpper(content), nil
+	}
+	for text, expected := range map[string]string{
+		"plain":                "plain",
+		"${a}":                 "A",
+		"x ${a} y ${b}":        "x A y B",
+		"$${a} ${a}":           "${a} A",
+		"${a:-${b}}":           "A:-${B}",
+		"$$ $ {a} }":           "$$ $ {a} }",
+		"cost: $5 ${currency}": "cost: $5 CURRENCY",
+	} {
+		actual, err := expandPlaceholders(text, resolve)
+		assert.Nil(err)
+		assert.Equal(expected, actual, text)
+	}
+	_, err := expandPlaceholders("x ${a", resolve)
+	assert.EqualError(err, "unterminated placeholder ${a")
+	_, err = expandPlaceholders("${fail}", resolve)
+	assert.EqualError(err, "failed")
+}
+
+func TestSplitScheme(t *testing.T) {
+	assert := assertions.New(t)
+	scheme, ref, ok := splitScheme("file:/run/secrets/db:pass")
+	assert.True(ok)
+	assert.Equal("file", scheme)
+	assert.Equal("/run/secrets/db:pass", ref)
+	for _, content := range []string{"db.password", "db:-default", ":x", "1x:y", "a.b:c"} {
+		_, _, ok := splitScheme(content)
+		assert.False(ok, content)
+	}
+}
+
+func TestExpandDocument(t *testing.T) {
+	assert := assertions.New(t)
+	document := map[string]any{
+		"db":    map[string]any{"password": "${secret}", "port": 5432},
+		"hosts": []any{"${host}", "${fail}"},
+		"name":  "${fail}",
+	}
+	changed, err := expandDocument(document, func(path, text string) (any, error) {
+		return expandPlaceholders(text, func(content string) (string, error) {
+			if content == "fail" {
+				return "", errors.New("failed")
+			}
+			return path, nil
+		})
+	})
+	assert.True(changed)
+	assert.Equal(map[string]any{
+		"db":    map[string]any{"password": "db.password", "port": 5432},
+		"hosts": []any{"hosts[0]", "${fail}"},
+		"name":  "${fail}",
+	}, document)
+	assert.Equal(errors.Join(
+		&PlaceholderError{Path: "hosts[1]", Value: "${fail}", Err: errors.New("failed")},
+		&PlaceholderError{Path: "name", Value: "${fail}", Err: errors.New("failed")},
+	), err)
+	assert.EqualError(err, "hosts[1]: can not resolve \"${fail}\": failed\nname: can not resolve \"${fail}\": failed")
+}
@@ -1,39 +1,619 @@
 package configurator
 
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
 type Registry interface {
+	// Order returns the agent names in the order Configure updates them: every agent comes
+	// after all of its requirements, independent agents are ordered by name.
+	Order() ([]string, error)
+	// Agents returns the names of all registered agents, sorted.
+	Agents() []string
+	// Lookup returns the agent registered under name.
+	Lookup(name string) (Agent, bool)
+	// Dependencies returns the names of the agents required by the agent, sorted.
+	Dependencies(name string) ([]string, error)
+	// Dependents returns the names of the registered agents which require the agent, sorted.
+	Dependents(name string) ([]string, error)
+	// Status reports whether the agent was configured and the error of its last update.
+	Status(name string) (AgentStatus, error)
+	// WriteDot writes the agent graph in the Graphviz DOT language, every edge leads from
+	// an agent to the agent it requires.
+	WriteDot(w io.Writer, options ...GraphOption) error
+	// WriteMermaid writes the agent graph as a Mermaid flowchart.
+	WriteMermaid(w io.Writer, options ...GraphOption) error
+	// Unregister removes the agent and detaches it from the agents it requires and the
+	// agents requiring it. While registered agents still require it a *DependentsError is
+	// returned, unless force is set. It waits for a running Configure to finish, so it must
+	// not be called from an update callback.
+	Unregister(name string, force bool) error
+	// Replace puts agent in place of the registered agent with the same name. The agents
+	// requiring the old agent require the new one instead, the requirements of the new agent
+	// are registered as well. The new agent is updated by the next Configure. Like
+	// Unregister it waits for a running Configure to finish.
+	Replace(agent Agent) error
+	// Register adds agent and all agents it requires, they are updated by the next
+	// Configure. Agents required by a registered agent later on are registered the same way.
+	Register(agent Agent) error
+	// Reset starts a new epoch of the registry: no agent is current anymore, see AgentStatus,
+	// and the sections recorded by WithChangeDetection are forgotten, so the next Configure
+	// updates all of them again. It waits for a running Configure to finish.
+	Reset()
 	get(key string) Agent
 	set(key string, agent Agent)
+	claim(key string, agent Agent) (registered Agent, claimed bool)
 	getAll() []Agent
+	order() ([]Agent, error)
+	beginRun() (end func())
+	epoch() uint64
+	nextEpoch()
+	openEpoch() string
+	setOpenEpoch(fingerprint string)
 }
 
+// firstEpoch is the epoch of a new registry.
+const firstEpoch = 1
+
 func NewModuleRegistry(rootAgents []Agent) (Registry, error) {
 	r := &moduleRegistry{
 		agents: make(map[string]Agent),
 	}
+	r.generation.Store(firstEpoch)
 	for _, agent := range rootAgents {
 		if err := agent.signUp(r); err != nil {
 			return nil, err
 		}
 	}
+	if err := findCycle(r.getAll(), r.get); err != nil {
+		return nil, err
+	}
+	r.adopt()
 	return r, nil
 }
 
+// moduleRegistry is safe for concurrent use. Configure runs over one registry are
+// serialised by beginRun, registrations by registerMutex.
 type moduleRegistry struct {
-	agents map[string]Agent
+	mutex         sync.RWMutex
+	runMutex      sync.Mutex
+	registerMutex sync.Mutex
+	agents        map[string]Agent
+	generation    atomic.Uint64
+	// open is the fingerprint of the document of the failed run which left the current
+	// epoch unfinished, empty otherwise. It is guarded by runMutex.
+	open string
 }
 
 func (r *moduleRegistry) get(key string) Agent {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	return r.agents[key]
 }
 
 func (r *moduleRegistry) set(key string, agent Agent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.agents[key] = agent
+}
+
+// claim registers agent under key unless the key is taken, and returns the agent registered
+// under key.
+func (r *moduleRegistry) claim(key string, agent Agent) (Agent, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if registered, ok := r.agents[key]; ok {
+		return registered, false
+	}
 	r.agents[key] = agent
+	return agent, true
 }
 
 func (r *moduleRegistry) getAll() []Agent {
-	list := make([]Agent, 0, len(r.agents))
-	for _, value := range r.agents {
-		list = append(list, value)
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return sortedAgents(r.agents)
+}
+
+func (r *moduleRegistry) Agents() []string {
+	return agentNames(r.getAll())
+}
+
+func (r *moduleRegistry) Lookup(name string) (Agent, bool) {
+	agent := r.get(name)
+	return agent, agent != nil
+}
+
+func (r *moduleRegistry) Dependencies(name string) ([]string, error) {
+	agent, err := r.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return agentNames(agent.children()), nil
+}
+
+func (r *moduleRegistry) Dependents(name string) ([]string, error) {
+	agent, err := r.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	dependents := make([]Agent, 0)
+	for _, parent := range agent.dependents() {
+		if r.get(parent.moduleName()) == parent {
+			dependents = append(dependents, parent)
+		}
+	}
+	return agentNames(dependents), nil
+}
+
+func (r *moduleRegistry) Status(name string) (AgentStatus, error) {
+	agent, err := r.lookup(name)
+	if err != nil {
+		return AgentStatus{}, err
+	}
+	return agent.status(), nil
+}
+
+func (r *moduleRegistry) WriteDot(w io.Writer, options ...GraphOption) error {
+	return writeDot(w, r, options)
+}
+
+func (r *moduleRegistry) WriteMermaid(w io.Writer, options ...GraphOption) error {
+	return writeMermaid(w, r, options)
+}
+
+func (r *moduleRegistry) Register(agent Agent) error {
+	r.registerMutex.Lock()
+	defer r.registerMutex.Unlock()
+	if err := findCycle([]Agent{agent}, resolver(r, agent)); err != nil {
+		return err
+	}
+	if err := r.findDuplicate(agent); err != nil {
+		return err
+	}
+	if err := agent.signUp(r); err != nil {
+		return err
+	}
+	r.adopt()
+	return nil
+}
+
+// findDuplicate looks for a name taken by different agents among agent, its requirements
+// and the registered agents.
+func (r *moduleRegistry) findDuplicate(agent Agent) error {
+	seen := make(map[string]Agent)
+	var visit func(agent Agent) error
+	visit = func(agent Agent) error {
+		name := agent.moduleName()
+		if other, ok := seen[name]; ok {
+			if other != agent {
+				return &DuplicateAgentError{Name: name}
+			}
+			return nil
+		}
+		seen[name] = agent
+		if registered := r.get(name); registered != nil {
+			if registered != agent {
+				return &DuplicateAgentError{Name: name}
+			}
+			return nil
+		}
+		for _, child := range agent.children() {
+			if err := visit(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return visit(agent)
+}
+
+// adopt lets every registered agent register the agents it requires later on.
+func (r *moduleRegistry) adopt() {
+	for _, agent := range r.getAll() {
+		agent.setRegistry(r)
+	}
+}
+
+func (r *moduleRegistry) Unregister(name string, force bool) error {
+	defer r.beginRun()()
+	agent, err := r.lookup(name)
+	if err != nil {
+		return err
+	}
+	dependents, err := r.Dependents(name)
+	if err != nil {
+		return err
+	}
+	if len(dependents) > 0 && !force {
+		return &DependentsError{Agent: name, Dependents: dependents}
+	}
+	detach(agent)
+	r.remove(name)
+	agent.setRegistry(nil)
+	return nil
+}
+
+func (r *moduleRegistry) Replace(agent Agent) error {
+	defer r.beginRun()()
+	r.registerMutex.Lock()
+	defer r.registerMutex.Unlock()
+	name := agent.moduleName()
+	old, err := r.lookup(name)
+	if err != nil {
+		return err
+	}
+	if old == agent {
+		return nil
+	}
+	if err := findCycle([]Agent{agent}, resolver(r, agent)); err != nil {
+		return err
+	}
+	if err := r.checkReplacement(agent); err != nil {
+		return err
+	}
+	return r.swap(old, agent)
+}
+
+// swap registers agent in place of old, the agents which required old require agent
+// instead. Should agent fail to register, old is put back. The caller is expected to hold
+// registerMutex.
+func (r *moduleRegistry) swap(old, agent Agent) error {
+	parents := old.dependents()
+	detach(old)
+	old.setRegistry(nil)
+	r.remove(old.moduleName())
+	if err := agent.signUp(r); err != nil {
+		r.set(old.moduleName(), old)
+		attach(old, parents)
+		r.adopt()
+		return err
+	}
+	attach(agent, parents)
+	r.adopt()
+	return nil
+}
+
+// checkReplacement makes sure that the requirements of agent neither lead back to the
+// agent it replaces nor clash with registered agents.
+func (r *moduleRegistry) checkReplacement(agent Agent) error {
+	name := agent.moduleName()
+	lookup := resolver(r, agent)
+	visited := make(map[string]bool)
+	path := []string{name}
+	var visit func(agent Agent) error
+	visit = func(agent Agent) error {
+		for _, child := range requirements(agent, lookup) {
+			childName := child.moduleName()
+			if childName == name {
+				return &CycleError{Path: append(slices.Clone(path), name)}
+			}
+			if registered := r.get(childName); registered != nil && registered != child {
+				return &DuplicateAgentError{Name: childName}
+			}
+			if visited[childName] {
+				continue
+			}
+			visited[childName] = true
+			path = append(path, childName)
+			if err := visit(child); err != nil {
+				return err
+			}
+			path = path[:len(path)-1]
+		}
+		return nil
+	}
+	return visit(agent)
+}
+
+// attach makes parents require the agent and the agent a parent of its children, it undoes
+// detach.
+func attach(agent Agent, parents []Agent) {
+	for _, parent := range parents {
+		parent.addChild(agent)
+		_ = agent.addParent(parent)
+	}
+	for _, child := range agent.children() {
+		_ = child.addParent(agent)
+	}
+}
+
+// detach removes the agent from the maps of its parents and children.
+func detach(agent Agent) {
+	for _, parent := range agent.dependents() {
+		parent.removeChild(agent)
+		agent.removeParent(parent)
+	}
+	for _, child := range agent.children() {
+		child.removeParent(agent)
+	}
+}
+
+func (r *moduleRegistry) remove(key string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.agents, key)
+}
+
+func (r *moduleRegistry) lookup(name string) (Agent, error) {
+	agent := r.get(name)
+	if agent == nil {
+		return nil, fmt.Errorf("%w %v", ErrUnknownAgent, name)
+	}
+	return agent, nil
+}
+
+func (r *moduleRegistry) Reset() {
+	defer r.beginRun()()
+	r.nextEpoch()
+	for _, agent := range r.getAll() {
+		agent.setFingerprint("")
+	}
+}
+
+func (r *moduleRegistry) epoch() uint64 {
+	return r.generation.Load()
+}
+
+// nextEpoch starts a new epoch, the caller is expected to hold the run lock.
+func (r *moduleRegistry) nextEpoch() {
+	r.generation.Add(1)
+	r.open = ""
+}
+
+// openEpoch returns the fingerprint of the document of the failed run which left the
+// current epoch to be resumed, if any. The caller is expected to hold the run lock.
+func (r *moduleRegistry) openEpoch() string {
+	return r.open
+}
+
+func (r *moduleRegistry) setOpenEpoch(fingerprint string) {
+	r.open = fingerprint
+}
+
+// beginRun waits until no other Configure runs over the registry.
+func (r *moduleRegistry) beginRun() (end func()) {
+	r.runMutex.Lock()
+	return r.runMutex.Unlock
+}
+
+func (r *moduleRegistry) Order() ([]string, error) {
+	agents, err := r.order()
+	if err != nil {
+		return nil, err
+	}
+	return agentNames(agents), nil
+}
+
+func agentNames(agents []Agent) []string {
+	names := make([]string, len(agents))
+	for i, agent := range agents {
+		names[i] = agent.moduleName()
+	}
+	return names
+}
+
+// order sorts the agents topologically, children first. Among the agents whose children
+// are all placed the one with the smallest name goes next.
+func (r *moduleRegistry) order() ([]Agent, error) {
+	agents := r.getAll()
+	graph := newDependencyGraph(agents)
+	ready := graph.ready()
+	list := make([]Agent, 0, len(agents))
+	for len(ready) > 0 {
+		agent := ready[0]
+		ready = ready[1:]
+		list = append(list, agent)
+		for _, parent := range graph.done(agent) {
+			index, _ := slices.BinarySearchFunc(ready, parent, func(x, y Agent) int {
+				return cmp.Compare(x.moduleName(), y.moduleName())
+			})
+			ready = slices.Insert(ready, index, parent)
+		}
+	}
+	if len(list) != len(agents) {
+		return nil, findCycle(agents, byName(agents))
+	}
+	return list, nil
+}
+
+// findCycle walks the dependency graph depth first and reports the first cycle found. The
+// links of the agents are resolved by lookup.
+func findCycle(agents []Agent, lookup func(name string) Agent) error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int, len(agents))
+	path := make([]string, 0, len(agents))
+	var visit func(agent Agent) error
+	visit = func(agent Agent) error {
+		name := agent.moduleName()
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return &CycleError{Path: append(slices.Clone(path[slices.Index(path, name):]), name)}
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, child := range requirements(agent, lookup) {
+			if err := visit(child); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+	for _, agent := range agents {
+		if err := visit(agent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requirements returns the agents the agent waits for: its children and the linked agents
+// found by lookup.
+func requirements(agent Agent, lookup func(name string) Agent) []Agent {
+	list := agent.children()
+	links := agent.links()
+	for _, name := range sortedNames(links) {
+		if linked := lookup(name); linked != nil {
+			list = append(list, linked)
+		}
+	}
+	return list
+}
+
+// resolver finds agents by name among roots, the agents they require and the registered
+// agents, in this order.
+func resolver(registry Registry, roots ...Agent) func(name string) Agent {
+	agents := make(map[string]Agent)
+	var collect func(agent Agent)
+	collect = func(agent Agent) {
+		if _, ok := agents[agent.moduleName()]; ok {
+			return
+		}
+		agents[agent.moduleName()] = agent
+		for _, child := range agent.children() {
+			collect(child)
+		}
+	}
+	for _, root := range roots {
+		collect(root)
+	}
+	return func(name string) Agent {
+		if agent, ok := agents[name]; ok {
+			return agent
+		}
+		return registry.get(name)
+	}
+}
+
+func byName(agents []Agent) func(name string) Agent {
+	index := make(map[string]Agent, len(agents))
+	for _, agent := range agents {
+		index[agent.moduleName()] = agent
+	}
+	return func(name string) Agent {
+		return index[name]
+	}
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// dependencyGraph tracks how many requirements of every agent are still not configured.
+// Requirements outside of the given agents are considered configured. The failure of an
+// agent is passed on to its dependents, but not to its followers, the agents linked to it
+// by After.
+type dependencyGraph struct {
+	agents     []Agent
+	pending    map[string]int
+	dependents map[string][]Agent
+	followers  map[string][]Agent
+	skipped    map[string]bool
+}
+
+func newDependencyGraph(agents []Agent) *dependencyGraph {
+	g := &dependencyGraph{
+		agents:     agents,
+		pending:    make(map[string]int, len(agents)),
+		dependents: make(map[string][]Agent, len(agents)),
+		followers:  make(map[string][]Agent),
+		skipped:    make(map[string]bool),
+	}
+	included := make(map[string]bool, len(agents))
+	for _, agent := range agents {
+		included[agent.moduleName()] = true
+	}
+	for _, agent := range agents {
+		for _, child := range agent.children() {
+			if !included[child.moduleName()] {
+				continue
+			}
+			g.pending[agent.moduleName()]++
+			g.dependents[child.moduleName()] = append(g.dependents[child.moduleName()], agent)
+		}
+		links := agent.links()
+		for _, name := range sortedNames(links) {
+			if !included[name] {
+				continue
+			}
+			g.pending[agent.moduleName()]++
+			if links[name] == orderLink {
+				g.followers[name] = append(g.followers[name], agent)
+			} else {
+				g.dependents[name] = append(g.dependents[name], agent)
+			}
+		}
+	}
+	return g
+}
+
+// ready returns the agents without requirements.
+func (g *dependencyGraph) ready() []Agent {
+	list := make([]Agent, 0, len(g.agents))
+	for _, agent := range g.agents {
+		if g.pending[agent.moduleName()] == 0 {
+			list = append(list, agent)
+		}
+	}
+	return list
+}
+
+// done marks the agent as configured and returns the dependents and followers which became
+// ready.
+func (g *dependencyGraph) done(agent Agent) []Agent {
+	list := make([]Agent, 0)
+	for _, parent := range slices.Concat(g.dependents[agent.moduleName()], g.followers[agent.moduleName()]) {
+		g.pending[parent.moduleName()]--
+		if g.pending[parent.moduleName()] == 0 {
+			list = append(list, parent)
+		}
+	}
+	return list
+}
+
+// fail marks the agent as failed and skips its direct and indirect dependents. It returns
+// the followers of these agents which became ready.
+func (g *dependencyGraph) fail(agent Agent) []Agent {
+	released := make([]Agent, 0)
+	queue := []Agent{agent}
+	for len(queue) > 0 {
+		name := queue[0].moduleName()
+		queue = queue[1:]
+		for _, parent := range g.dependents[name] {
+			if !g.skipped[parent.moduleName()] {
+				g.skipped[parent.moduleName()] = true
+				queue = append(queue, parent)
+			}
+		}
+		for _, follower := range g.followers[name] {
+			g.pending[follower.moduleName()]--
+			if g.pending[follower.moduleName()] == 0 {
+				released = append(released, follower)
+			}
+		}
+	}
+	list := make([]Agent, 0, len(released))
+	for _, follower := range released {
+		if !g.skipped[follower.moduleName()] {
+			list = append(list, follower)
+		}
 	}
 	return list
 }
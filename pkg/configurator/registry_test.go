@@ -2,9 +2,12 @@ package configurator
 
 import (
 	"cmp"
+	"errors"
+	"fmt"
 	assertions "github.com/stretchr/testify/assert"
 	"io"
 	"slices"
+	"sync"
 	"testing"
 )
 
@@ -105,14 +108,51 @@ func TestLoop(t *testing.T) {
 	agent3.Require(agent4)
 	agent4.Require(agent1)
 
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.EqualError(err, "dependency cycle detected: 1 -> 2 -> 3 -> 4 -> 1")
+	assert.Nil(registry)
+}
+
+func TestLoopInsideHierarchy(t *testing.T) {
+	assert := assertions.New(t)
+	db := NewAgent("db", func(r io.Reader, format string) error { return nil })
+	cache := NewAgent("cache", func(r io.Reader, format string) error { return nil })
+	app := NewAgent("app", func(r io.Reader, format string) error { return nil })
+
+	app.Require(db)
+	db.Require(cache)
+	cache.Require(db)
+
+	registry, err := NewModuleRegistry([]Agent{app})
+	assert.EqualError(err, "dependency cycle detected: db -> cache -> db")
+	assert.Nil(registry)
+}
+
+func TestSelfRequirement(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent1)
+
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.EqualError(err, "agent 1 requires itself")
+	assert.Nil(registry)
+}
+
+func TestDiamondIsNotLoop(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	agent4 := NewAgent("4", func(r io.Reader, format string) error { return nil })
+
+	agent1.Require(agent2)
+	agent1.Require(agent3)
+	agent2.Require(agent4)
+	agent3.Require(agent4)
+
 	registry, err := NewModuleRegistry([]Agent{agent1})
 	assert.Nil(err)
-	agents := registry.getAll()
-	slices.SortFunc(agents, func(a, b Agent) int {
-		return cmp.Compare(a.moduleName(), b.moduleName())
-	})
-	expectedAgents := []Agent{agent1, agent2, agent3, agent4}
-	assert.Equal(expectedAgents, agents)
+	assert.Len(registry.getAll(), 4)
 }
 
 func TestTestReplacement(t *testing.T) {
@@ -133,3 +173,383 @@ func TestTestReplacementNoError(t *testing.T) {
 	assert.Nil(err)
 	assert.NotNil(registry)
 }
+
+func TestOrder(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	agent4 := NewAgent("4", func(r io.Reader, format string) error { return nil })
+	agent5 := NewAgent("5", func(r io.Reader, format string) error { return nil })
+
+	agent1.Require(agent3)
+	agent1.Require(agent2)
+	agent2.Require(agent4)
+	agent3.Require(agent4)
+
+	registry, err := NewModuleRegistry([]Agent{agent5, agent1})
+	assert.Nil(err)
+	for i := 0; i < 10; i++ {
+		order, err := registry.Order()
+		assert.Nil(err)
+		assert.Equal([]string{"4", "2", "3", "1", "5"}, order)
+	}
+}
+
+func TestOrderEmptyRegistry(t *testing.T) {
+	assert := assertions.New(t)
+	registry, err := NewModuleRegistry([]Agent{})
+	assert.Nil(err)
+	order, err := registry.Order()
+	assert.Nil(err)
+	assert.Empty(order)
+}
+
+func TestRequireConcurrent(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			child := NewAgent(fmt.Sprint("child", i), func(r io.Reader, format string) error { return nil })
+			assert.Nil(agent1.Require(child))
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := registry.Order()
+			assert.Nil(err)
+		}()
+	}
+	wg.Wait()
+	order, err := registry.Order()
+	assert.Nil(err)
+	assert.Len(order, 11)
+	assert.Equal("1", order[10])
+}
+
+func TestIntrospection(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	unregistered := NewAgent("unregistered", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent3)
+	agent1.Require(agent2)
+	agent2.Require(agent3)
+	unregistered.Require(agent3)
+
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	assert.Equal([]string{"1", "2", "3"}, registry.Agents())
+
+	agent, ok := registry.Lookup("2")
+	assert.True(ok)
+	assert.Equal(agent2, agent)
+	_, ok = registry.Lookup("unregistered")
+	assert.False(ok)
+
+	dependencies, err := registry.Dependencies("1")
+	assert.Nil(err)
+	assert.Equal([]string{"2", "3"}, dependencies)
+	dependencies, err = registry.Dependencies("3")
+	assert.Nil(err)
+	assert.Empty(dependencies)
+
+	dependents, err := registry.Dependents("3")
+	assert.Nil(err)
+	assert.Equal([]string{"1", "2"}, dependents)
+	dependents, err = registry.Dependents("1")
+	assert.Nil(err)
+	assert.Empty(dependents)
+
+	_, err = registry.Dependencies("unregistered")
+	assert.ErrorIs(err, ErrUnknownAgent)
+	_, err = registry.Dependents("unregistered")
+	assert.ErrorIs(err, ErrUnknownAgent)
+	_, err = registry.Status("unregistered")
+	assert.ErrorIs(err, ErrUnknownAgent)
+}
+
+func TestUnregister(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	agent2.Require(agent3)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+
+	err = registry.Unregister("2", false)
+	assert.Equal(&DependentsError{Agent: "2", Dependents: []string{"1"}}, err)
+	assert.ErrorIs(err, ErrHasDependents)
+	assert.EqualError(err, "agent 2 is required by 1")
+	assert.Equal([]string{"1", "2", "3"}, registry.Agents())
+
+	assert.Nil(registry.Unregister("1", false))
+	assert.Equal([]string{"2", "3"}, registry.Agents())
+	dependents, err := registry.Dependents("2")
+	assert.Nil(err)
+	assert.Empty(dependents)
+
+	assert.ErrorIs(registry.Unregister("1", false), ErrUnknownAgent)
+}
+
+func TestUnregisterForce(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	agent2.Require(agent3)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+
+	assert.Nil(registry.Unregister("2", true))
+	assert.Equal([]string{"1", "3"}, registry.Agents())
+	assert.Empty(agent1.children())
+	assert.Empty(agent3.dependents())
+	order, err := registry.Order()
+	assert.Nil(err)
+	assert.Equal([]string{"1", "3"}, order)
+}
+
+func TestReplace(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	agent2.Require(agent3)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+
+	newAgent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent4 := NewAgent("4", func(r io.Reader, format string) error { return nil })
+	newAgent2.Require(agent4)
+	assert.Nil(registry.Replace(newAgent2))
+
+	agent, _ := registry.Lookup("2")
+	assert.Equal(newAgent2, agent)
+	assert.Equal([]string{"1", "2", "3", "4"}, registry.Agents())
+	dependencies, err := registry.Dependencies("1")
+	assert.Nil(err)
+	assert.Equal([]string{"2"}, dependencies)
+	assert.True(agent1.children()[0] == newAgent2)
+	assert.Empty(agent2.dependents())
+	assert.Empty(agent3.dependents())
+	order, err := registry.Order()
+	assert.Nil(err)
+	assert.Equal([]string{"3", "4", "2", "1"}, order)
+}
+
+func TestReplaceErrors(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	agent2.Require(agent3)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+
+	unknown := NewAgent("unknown", func(r io.Reader, format string) error { return nil })
+	assert.ErrorIs(registry.Replace(unknown), ErrUnknownAgent)
+
+	cyclic := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	cyclic.Require(agent1)
+	assert.Equal(&CycleError{Path: []string{"2", "1", "2"}}, registry.Replace(cyclic))
+
+	duplicate := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	duplicate.Require(NewAgent("3", func(r io.Reader, format string) error { return nil }))
+	assert.Equal(&DuplicateAgentError{Name: "3"}, registry.Replace(duplicate))
+
+	agent, _ := registry.Lookup("2")
+	assert.Equal(agent2, agent)
+	assert.True(agent1.children()[0] == agent2)
+}
+
+// unregistrable is an agent which fails to register.
+type unregistrable struct {
+	*agentImpl
+}
+
+func (a unregistrable) signUp(registry Registry) error {
+	return errors.New("can not register")
+}
+
+func TestReplaceRestoresOnFailure(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	agent2.Require(agent3)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+
+	replacement := unregistrable{NewAgent("2", func(r io.Reader, format string) error { return nil }).(*agentImpl)}
+	assert.EqualError(registry.Replace(replacement), "can not register")
+	agent, _ := registry.Lookup("2")
+	assert.Equal(agent2, agent)
+	dependents, err := registry.Dependents("2")
+	assert.Nil(err)
+	assert.Equal([]string{"1"}, dependents)
+	dependents, err = registry.Dependents("3")
+	assert.Nil(err)
+	assert.Equal([]string{"2"}, dependents)
+	order, err := registry.Order()
+	assert.Nil(err)
+	assert.Equal([]string{"3", "2", "1"}, order)
+}
+
+func TestRequireAfterRegistration(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+
+	agent2.Require(agent3)
+	assert.Nil(agent1.Require(agent2))
+	assert.Equal([]string{"1", "2", "3"}, registry.Agents())
+	agent4 := NewAgent("4", func(r io.Reader, format string) error { return nil })
+	assert.Nil(agent3.Require(agent4))
+	order, err := registry.Order()
+	assert.Nil(err)
+	assert.Equal([]string{"4", "3", "2", "1"}, order)
+}
+
+func TestRequireAfterRegistrationErrors(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+
+	err = agent2.Require(agent1)
+	assert.Equal(&CycleError{Path: []string{"2", "1", "2"}}, err)
+	assert.Empty(agent2.children())
+	assert.Len(agent1.dependents(), 0)
+
+	duplicate := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	assert.Equal(&DuplicateAgentError{Name: "1"}, agent2.Require(duplicate))
+	assert.Empty(agent2.children())
+	assert.Equal([]string{"1", "2"}, registry.Agents())
+
+	// a clashing requirement leaves the one it would replace in place
+	assert.Equal(&DuplicateAgentError{Name: "2"}, agent1.Require(NewAgent("2", func(r io.Reader, format string) error { return nil })))
+	dependencies, err := registry.Dependencies("1")
+	assert.Nil(err)
+	assert.Equal([]string{"2"}, dependencies)
+	dependents, err := registry.Dependents("2")
+	assert.Nil(err)
+	assert.Equal([]string{"1"}, dependents)
+}
+
+func TestRegisterConcurrentDuplicates(t *testing.T) {
+	assert := assertions.New(t)
+	registry, err := NewModuleRegistry(nil)
+	assert.Nil(err)
+	agents := make([]Agent, 8)
+	errs := make([]error, len(agents))
+	wg := sync.WaitGroup{}
+	for i := range agents {
+		agents[i] = NewAgent("1", func(r io.Reader, format string) error { return nil })
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = registry.Register(agents[i])
+		}()
+	}
+	wg.Wait()
+	registered, ok := registry.Lookup("1")
+	assert.True(ok)
+	for i, err := range errs {
+		if agents[i] == registered {
+			assert.Nil(err)
+		} else {
+			assert.Equal(&DuplicateAgentError{Name: "1"}, err)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	agent2.Require(agent3)
+	assert.Nil(registry.Register(agent2))
+	assert.Nil(registry.Register(agent2))
+	assert.Equal([]string{"1", "2", "3"}, registry.Agents())
+
+	agent4 := NewAgent("4", func(r io.Reader, format string) error { return nil })
+	assert.Nil(agent2.Require(agent4))
+	assert.Equal([]string{"1", "2", "3", "4"}, registry.Agents())
+
+	other := NewAgent("5", func(r io.Reader, format string) error { return nil })
+	other.Require(NewAgent("3", func(r io.Reader, format string) error { return nil }))
+	assert.Equal(&DuplicateAgentError{Name: "3"}, registry.Register(other))
+	assert.Equal([]string{"1", "2", "3", "4"}, registry.Agents())
+}
+
+func TestOrderWithLinks(t *testing.T) {
+	assert := assertions.New(t)
+	server := NewAgent("server", func(r io.Reader, format string) error { return nil })
+	metrics := NewAgent("metrics", func(r io.Reader, format string) error { return nil })
+	cache := NewAgent("cache", func(r io.Reader, format string) error { return nil })
+	tracing := NewAgent("tracing", func(r io.Reader, format string) error { return nil })
+	server.RequireOptional(metrics)
+	server.RequireOptional(cache)
+	server.After(tracing)
+	registry, err := NewModuleRegistry([]Agent{server, metrics})
+	assert.Nil(err)
+	assert.Equal([]string{"metrics", "server"}, registry.Agents())
+	order, err := registry.Order()
+	assert.Nil(err)
+	assert.Equal([]string{"metrics", "server"}, order)
+
+	assert.Nil(registry.Register(tracing))
+	order, err = registry.Order()
+	assert.Nil(err)
+	assert.Equal([]string{"metrics", "tracing", "server"}, order)
+	dependencies, err := registry.Dependencies("server")
+	assert.Nil(err)
+	assert.Empty(dependencies)
+	assert.Nil(registry.Unregister("metrics", false))
+}
+
+func TestLinkLoop(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	agent2.After(agent1)
+	_, err := NewModuleRegistry([]Agent{agent1})
+	assert.Equal(&CycleError{Path: []string{"1", "2", "1"}}, err)
+
+	agent3 := NewAgent("3", func(r io.Reader, format string) error { return nil })
+	agent4 := NewAgent("4", func(r io.Reader, format string) error { return nil })
+	agent3.Require(agent4)
+	registry, err := NewModuleRegistry([]Agent{agent3})
+	assert.Nil(err)
+	assert.Equal(&CycleError{Path: []string{"4", "3", "4"}}, agent4.RequireOptional(agent3))
+	assert.Empty(agent4.links())
+
+	agent5 := NewAgent("5", func(r io.Reader, format string) error { return nil })
+	agent5.After(agent3)
+	agent4.After(agent5)
+	assert.Equal(&CycleError{Path: []string{"5", "3", "4", "5"}}, registry.Register(agent5))
+	assert.Equal([]string{"3", "4"}, registry.Agents())
+}
@@ -0,0 +1,108 @@
+package configurator
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Decision tells what a Configure run did with an agent.
+type Decision int
+
+const (
+	// NotRun agents were pending but not called, because a requirement failed or the run
+	// was stopped.
+	NotRun Decision = iota
+	// Updated agents had their update callback called successfully.
+	Updated
+	// Unchanged agents were not called since neither their section nor their requirements
+	// changed, see WithChangeDetection.
+	Unchanged
+	// UpToDate agents were configured in the current epoch of the registry already, by a
+	// failed run which the current one resumes.
+	UpToDate
+	// Failed agents returned an error from the update callback or the commit.
+	Failed
+	// RolledBack agents were prepared by a transactional run which failed afterwards.
+	RolledBack
+)
+
+func (d Decision) String() string {
+	switch d {
+	case NotRun:
+		return "not run"
+	case Updated:
+		return "updated"
+	case Unchanged:
+		return "unchanged"
+	case UpToDate:
+		return "up to date"
+	case Failed:
+		return "failed"
+	case RolledBack:
+		return "rolled back"
+	default:
+		return fmt.Sprintf("Decision(%d)", int(d))
+	}
+}
+
+// AgentReport is the outcome of a Configure run for one agent. Reason explains why an agent
+// was updated or left unchanged when change detection is enabled, Err is set for a failed
+// agent.
+type AgentReport struct {
+	Name     string
+	Decision Decision
+	Reason   string
+	Err      error
+}
+
+// Report describes a Configure run, it lists every registered agent in the order of the run.
+type Report struct {
+	Agents []AgentReport
+}
+
+// Agent returns the outcome for the agent called name.
+func (r *Report) Agent(name string) (AgentReport, bool) {
+	for _, agent := range r.Agents {
+		if agent.Name == name {
+			return agent, true
+		}
+	}
+	return AgentReport{}, false
+}
+
+// recorder collects the outcomes of a run, it is shared by the steps running in parallel.
+type recorder struct {
+	mutex    sync.Mutex
+	outcomes map[string]AgentReport
+}
+
+func newRecorder() *recorder {
+	return &recorder{outcomes: make(map[string]AgentReport)}
+}
+
+func (r *recorder) record(agent Agent, decision Decision, reason string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.outcomes[agent.moduleName()] = AgentReport{Name: agent.moduleName(), Decision: decision, Reason: reason, Err: err}
+}
+
+func (r *recorder) decision(name string) Decision {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.outcomes[name].Decision
+}
+
+// report lists the outcomes of agents, the agents without an outcome were not run.
+func (r *recorder) report(agents []Agent) *Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	report := &Report{Agents: make([]AgentReport, len(agents))}
+	for i, agent := range agents {
+		outcome, ok := r.outcomes[agent.moduleName()]
+		if !ok {
+			outcome = AgentReport{Name: agent.moduleName(), Decision: NotRun}
+		}
+		report.Agents[i] = outcome
+	}
+	return report
+}
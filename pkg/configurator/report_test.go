@@ -0,0 +1,157 @@
+package configurator
+
+import (
+	"context"
+	"errors"
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigureReport(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	someError := errors.New("some error")
+	logger := NewAgent("logger", func(r io.Reader, format string) error { return nil })
+	db := NewAgent("db", func(r io.Reader, format string) error { return someError })
+	server := NewAgent("server", func(r io.Reader, format string) error { return nil })
+	server.Require(db)
+	db.Require(logger)
+	registry, err := NewModuleRegistry([]Agent{server})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+	report, err := configurator.ConfigureReport(context.Background())
+	assert.ErrorIs(err, someError)
+	assert.Equal(&Report{Agents: []AgentReport{
+		{Name: "logger", Decision: Updated},
+		{Name: "db", Decision: Failed, Err: someError},
+		{Name: "server", Decision: NotRun},
+	}}, report)
+
+	report, err = configurator.ConfigureReport(context.Background())
+	assert.ErrorIs(err, someError)
+	agent, ok := report.Agent("logger")
+	assert.True(ok)
+	assert.Equal(UpToDate, agent.Decision)
+	_, ok = report.Agent("unknown")
+	assert.False(ok)
+
+	configurator = NewLocalConfigurator(registry, []string{"not-found.yaml"}, "yaml")
+	report, err = configurator.ConfigureReport(context.Background())
+	assert.ErrorIs(err, ErrNoConfigFile)
+	assert.Nil(report)
+}
+
+func TestChangeDetection(t *testing.T) {
+	assert := assertions.New(t)
+	path := filepath.Join(t.TempDir(), "test.config.yaml")
+	assert.Nil(os.WriteFile(path, []byte("logging:\n  level: info\nhttp:\n  port: 80\nworker:\n  count: 1\n"), 0640))
+	updates := make([]string, 0)
+	newAgent := func(name string) Agent {
+		return NewAgent(name, func(r io.Reader, format string) error {
+			updates = append(updates, name)
+			return nil
+		}, WithSection(name))
+	}
+	logging := newAgent("logging")
+	http := newAgent("http")
+	worker := newAgent("worker")
+	worker.Require(logging)
+	registry, err := NewModuleRegistry([]Agent{http, worker})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithChangeDetection())
+
+	report, err := configurator.ConfigureReport(context.Background())
+	assert.Nil(err)
+	assert.Equal(&Report{Agents: []AgentReport{
+		{Name: "http", Decision: Updated, Reason: "not updated before"},
+		{Name: "logging", Decision: Updated, Reason: "not updated before"},
+		{Name: "worker", Decision: Updated, Reason: "not updated before"},
+	}}, report)
+	first, err := registry.Status("http")
+	assert.Nil(err)
+
+	// comments and formatting do not count as a change
+	assert.Nil(os.WriteFile(path, []byte("# production\nlogging: {level: debug}\nhttp: {port: 80}\nworker: {count: 1}\n"), 0640))
+	updates = updates[:0]
+	report, err = configurator.ConfigureReport(context.Background())
+	assert.Nil(err)
+	assert.Equal(&Report{Agents: []AgentReport{
+		{Name: "http", Decision: Unchanged, Reason: "section unchanged"},
+		{Name: "logging", Decision: Updated, Reason: "section changed"},
+		{Name: "worker", Decision: Updated, Reason: "requirement logging updated"},
+	}}, report)
+	assert.Equal([]string{"logging", "worker"}, updates)
+	status, err := registry.Status("http")
+	assert.Nil(err)
+	assert.True(status.Configured)
+	assert.True(status.Current)
+	assert.Equal(first.ConfiguredAt, status.ConfiguredAt)
+
+	updates = updates[:0]
+	report, err = configurator.ConfigureReport(context.Background())
+	assert.Nil(err)
+	for _, agent := range report.Agents {
+		assert.Equal(Unchanged, agent.Decision)
+	}
+	assert.Empty(updates)
+
+	// a reset updates every agent again
+	registry.Reset()
+	report, err = configurator.ConfigureReport(context.Background())
+	assert.Nil(err)
+	for _, agent := range report.Agents {
+		assert.Equal(Updated, agent.Decision)
+		assert.Equal("not updated before", agent.Reason)
+	}
+	assert.Equal([]string{"http", "logging", "worker"}, updates)
+}
+
+func TestChangeDetectionTransactional(t *testing.T) {
+	assert := assertions.New(t)
+	path := filepath.Join(t.TempDir(), "test.config.yaml")
+	assert.Nil(os.WriteFile(path, []byte("logging:\n  level: info\nhttp:\n  port: 80\n"), 0640))
+	someError := errors.New("some error")
+	fail := false
+	logging := NewAgent("logging", func(r io.Reader, format string) error { return nil }, WithSection("logging"))
+	http := NewAgent("http", func(r io.Reader, format string) error {
+		if fail {
+			return someError
+		}
+		return nil
+	}, WithSection("http"))
+	registry, err := NewModuleRegistry([]Agent{logging, http})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithChangeDetection(), WithTransactional())
+	_, err = configurator.ConfigureReport(context.Background())
+	assert.Nil(err)
+
+	assert.Nil(os.WriteFile(path, []byte("logging:\n  level: debug\nhttp:\n  port: 8080\n"), 0640))
+	fail = true
+	report, err := configurator.ConfigureReport(context.Background())
+	assert.Equal(&AgentError{Agent: "http", Err: someError}, err)
+	assert.Equal(&Report{Agents: []AgentReport{
+		{Name: "http", Decision: Failed, Reason: "section changed", Err: someError},
+		{Name: "logging", Decision: NotRun},
+	}}, report)
+
+	assert.Nil(os.WriteFile(path, []byte("logging:\n  level: debug\nhttp:\n  port: 80\n"), 0640))
+	fail = false
+	report, err = configurator.ConfigureReport(context.Background())
+	assert.Nil(err)
+	assert.Equal(&Report{Agents: []AgentReport{
+		{Name: "http", Decision: Updated, Reason: "not updated before"},
+		{Name: "logging", Decision: Updated, Reason: "section changed"},
+	}}, report)
+}
+
+func TestDecisionString(t *testing.T) {
+	assert := assertions.New(t)
+	assert.Equal("unchanged", Unchanged.String())
+	assert.Equal("rolled back", RolledBack.String())
+	assert.Equal("Decision(42)", Decision(42).String())
+}
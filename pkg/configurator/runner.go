@@ -0,0 +1,95 @@
+package configurator
+
+import "context"
+
+// run calls step for every agent, children before their parents. It stops at the first
+// error, returned as an *AgentError, unless c.continueOnError is set. Then the dependents of
+// failed agents are skipped and a *ConfigureError is returned. No agent is started after
+// ctx is done.
+func (c *configuratorImpl) run(ctx context.Context, agents []Agent, step func(agent Agent) error) error {
+	failures := make(map[string]error)
+	var err error
+	if c.workers > 1 {
+		err = c.runParallel(ctx, agents, step, failures)
+	} else {
+		err = c.runSerial(ctx, agents, step, failures)
+	}
+	if err != nil || len(failures) == 0 {
+		return err
+	}
+	return newConfigureError(agents, failures)
+}
+
+func (c *configuratorImpl) runSerial(ctx context.Context, agents []Agent, step func(agent Agent) error, failures map[string]error) error {
+	skipped := make(map[string]bool)
+	for _, agent := range agents {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		blocked := false
+		for _, child := range agent.children() {
+			_, failed := failures[child.moduleName()]
+			blocked = blocked || failed || skipped[child.moduleName()]
+		}
+		for name, kind := range agent.links() {
+			_, failed := failures[name]
+			blocked = blocked || kind == optionalLink && (failed || skipped[name])
+		}
+		if blocked {
+			skipped[agent.moduleName()] = true
+			continue
+		}
+		if err := step(agent); err != nil {
+			if !c.continueOnError {
+				return &AgentError{Agent: agent.moduleName(), Err: err}
+			}
+			failures[agent.moduleName()] = err
+		}
+	}
+	return nil
+}
+
+type stepResult struct {
+	agent Agent
+	err   error
+}
+
+// runParallel calls step in a pool of c.workers goroutines. Unless c.continueOnError is
+// set, no more agents are started after the first error and the error is returned once the
+// running ones finish.
+func (c *configuratorImpl) runParallel(ctx context.Context, agents []Agent, step func(agent Agent) error, failures map[string]error) error {
+	graph := newDependencyGraph(agents)
+	ready := graph.ready()
+	results := make(chan stepResult)
+	running := 0
+	var err error
+	for len(ready) > 0 || running > 0 {
+		if err == nil {
+			err = ctx.Err()
+		}
+		for err == nil && len(ready) > 0 && running < c.workers {
+			agent := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				results <- stepResult{agent: agent, err: step(agent)}
+			}()
+		}
+		if running == 0 {
+			break
+		}
+		result := <-results
+		running--
+		if result.err != nil {
+			if c.continueOnError {
+				failures[result.agent.moduleName()] = result.err
+				ready = append(ready, graph.fail(result.agent)...)
+			} else if err == nil {
+				err = &AgentError{Agent: result.agent.moduleName(), Err: result.err}
+			}
+			continue
+		}
+		ready = append(ready, graph.done(result.agent)...)
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package configurator
+
+import (
+	"errors"
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfigureContinueOnError(t *testing.T) {
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assertions.Nil(t, err)
+	for _, workers := range []int{1, 4} {
+		assert := assertions.New(t)
+		now := time.Now()
+		dbError := errors.New("db error")
+		metricsError := errors.New("metrics error")
+		var mutex sync.Mutex
+		sequence := make([]string, 0)
+		newAgent := func(name string, err error) Agent {
+			return NewAgent(name, func(r io.Reader, format string) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				sequence = append(sequence, name)
+				return err
+			})
+		}
+		logger := newAgent("logger", nil)
+		db := newAgent("db", dbError)
+		cache := newAgent("cache", nil)
+		repository := newAgent("repository", nil)
+		server := newAgent("server", nil)
+		metrics := newAgent("metrics", metricsError)
+		db.Require(logger)
+		cache.Require(logger)
+		repository.Require(db)
+		server.Require(repository)
+		server.Require(cache)
+		server.Require(metrics)
+		registry, err := NewModuleRegistry([]Agent{server})
+		assert.Nil(err)
+		configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithContinueOnError(), WithParallelism(workers))
+
+		err = configurator.Configure()
+		var configureError *ConfigureError
+		assert.ErrorAs(err, &configureError)
+		assert.Equal([]AgentFailure{
+			{Agent: "db", Err: dbError, Skipped: []string{"repository", "server"}},
+			{Agent: "metrics", Err: metricsError, Skipped: []string{"server"}},
+		}, configureError.Failures)
+		assert.ErrorIs(err, dbError)
+		assert.ErrorIs(err, metricsError)
+		assert.Equal("configuration failed:\n  db: db error (skipped repository, server)\n  metrics: metrics error (skipped server)", err.Error())
+		slices.Sort(sequence)
+		assert.Equal([]string{"cache", "db", "logger", "metrics"}, sequence)
+		assert.True(cache.isConfigured(now))
+		assert.False(repository.isConfigured(now))
+		assert.False(server.isConfigured(now))
+	}
+}
+
+func TestConfigureContinueOnErrorWithoutErrors(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error { return nil })
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithContinueOnError())
+
+	err = configurator.Configure()
+	assert.Nil(err)
+}
+
+func TestConfigureContinueOnErrorWithLinks(t *testing.T) {
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assertions.Nil(t, err)
+	for _, workers := range []int{1, 4} {
+		assert := assertions.New(t)
+		now := time.Now()
+		metricsError := errors.New("metrics error")
+		var mutex sync.Mutex
+		sequence := make([]string, 0)
+		newAgent := func(name string, err error) Agent {
+			return NewAgent(name, func(r io.Reader, format string) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				sequence = append(sequence, name)
+				return err
+			})
+		}
+		metrics := newAgent("metrics", metricsError)
+		tracing := newAgent("tracing", nil)
+		server := newAgent("server", nil)
+		worker := newAgent("worker", nil)
+		server.After(metrics)
+		server.After(tracing)
+		worker.RequireOptional(metrics)
+		registry, err := NewModuleRegistry([]Agent{server, worker, metrics, tracing})
+		assert.Nil(err)
+		configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithContinueOnError(), WithParallelism(workers))
+
+		err = configurator.Configure()
+		var configureError *ConfigureError
+		assert.ErrorAs(err, &configureError)
+		assert.Equal([]AgentFailure{
+			{Agent: "metrics", Err: metricsError, Skipped: []string{"worker"}},
+		}, configureError.Failures)
+		assert.Equal("server", sequence[len(sequence)-1])
+		slices.Sort(sequence)
+		assert.Equal([]string{"metrics", "server", "tracing"}, sequence)
+		assert.True(server.isConfigured(now))
+		assert.False(worker.isConfigured(now))
+	}
+}
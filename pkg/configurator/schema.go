@@ -0,0 +1,170 @@
+package configurator
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Violation is a mismatch between the document and the schema of an agent. A schema is a
+// struct whose fields are named by their yaml tags. Keys without a field are reported, the
+// values must be convertible to the field types and the rules of the validate tag hold:
+//
+//	required    the key is present and not null
+//	min=N       numbers are at least N, strings, lists and maps have at least N items
+//	max=N       numbers are at most N, strings, lists and maps have at most N items
+//	oneof=a b   the value is one of the space separated alternatives
+type Violation struct {
+	Path    string
+	Message string
+}
+
+// ValidationError lists every violation found in the document.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	builder := strings.Builder{}
+	builder.WriteString("configuration is invalid:")
+	for _, violation := range e.Violations {
+		fmt.Fprintf(&builder, "\n  %v: %v", violation.Path, violation.Message)
+	}
+	return builder.String()
+}
+
+func validateSchema(path string, value any, schema reflect.Type) []Violation {
+	for schema.Kind() == reflect.Pointer {
+		schema = schema.Elem()
+	}
+	switch schema.Kind() {
+	case reflect.Struct:
+		return validateStruct(path, value, schema)
+	case reflect.Slice, reflect.Array:
+		if value == nil {
+			return nil
+		}
+		items, ok := value.([]any)
+		if !ok {
+			return []Violation{{Path: path, Message: "expected a list"}}
+		}
+		violations := make([]Violation, 0)
+		for i, item := range items {
+			violations = append(violations, validateSchema(fmt.Sprintf("%v[%v]", path, i), item, schema.Elem())...)
+		}
+		return violations
+	case reflect.Map:
+		if value == nil {
+			return nil
+		}
+		items, ok := value.(map[string]any)
+		if !ok {
+			return []Violation{{Path: path, Message: "expected a map"}}
+		}
+		violations := make([]Violation, 0)
+		for _, key := range sortedKeys(items) {
+			violations = append(violations, validateSchema(joinPath(path, key), items[key], schema.Elem())...)
+		}
+		return violations
+	case reflect.Interface:
+		return nil
+	default:
+		if value == nil {
+			return nil
+		}
+		if err := convert(value, reflect.New(schema).Interface()); err != nil {
+			return []Violation{{Path: path, Message: fmt.Sprintf("expected %v", schema)}}
+		}
+		return nil
+	}
+}
+
+func validateStruct(path string, value any, schema reflect.Type) []Violation {
+	fields := make(map[string]any)
+	if value != nil {
+		var ok bool
+		if fields, ok = value.(map[string]any); !ok {
+			return []Violation{{Path: path, Message: "expected a map"}}
+		}
+	}
+	violations := make([]Violation, 0)
+	known := make(map[string]bool)
+	for _, field := range yamlFields(schema) {
+		name := field.key
+		known[name] = true
+		fieldPath := joinPath(path, name)
+		item, present := fields[name]
+		rules := strings.Split(field.Tag.Get("validate"), ",")
+		if !present || item == nil {
+			if slices.Contains(rules, "required") {
+				violations = append(violations, Violation{Path: fieldPath, Message: "is required"})
+			}
+			continue
+		}
+		fieldViolations := validateSchema(fieldPath, item, field.Type)
+		if len(fieldViolations) == 0 {
+			fieldViolations = checkRules(fieldPath, item, field.Type, rules)
+		}
+		violations = append(violations, fieldViolations...)
+	}
+	for _, key := range sortedKeys(fields) {
+		if !known[key] {
+			violations = append(violations, Violation{Path: joinPath(path, key), Message: "unknown key"})
+		}
+	}
+	return violations
+}
+
+func checkRules(path string, value any, schema reflect.Type, rules []string) []Violation {
+	violations := make([]Violation, 0)
+	for _, rule := range rules {
+		name, argument, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(argument, 64)
+			if err != nil {
+				violations = append(violations, Violation{Path: path, Message: fmt.Sprintf("invalid rule %v", rule)})
+				continue
+			}
+			size, measure := measure(value, schema)
+			if name == "min" && size < limit {
+				violations = append(violations, Violation{Path: path, Message: fmt.Sprintf("%v must be at least %v", measure, argument)})
+			}
+			if name == "max" && size > limit {
+				violations = append(violations, Violation{Path: path, Message: fmt.Sprintf("%v must be at most %v", measure, argument)})
+			}
+		case "oneof":
+			if !slices.Contains(strings.Fields(argument), fmt.Sprint(value)) {
+				violations = append(violations, Violation{Path: path, Message: fmt.Sprintf("must be one of %v", argument)})
+			}
+		}
+	}
+	return violations
+}
+
+// measure returns the value of a number or the length of anything else.
+func measure(value any, schema reflect.Type) (float64, string) {
+	for schema.Kind() == reflect.Pointer {
+		schema = schema.Elem()
+	}
+	switch schema.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(reflect.ValueOf(value).Len()), "length"
+	case reflect.String:
+		return float64(len(fmt.Sprint(value))), "length"
+	}
+	number, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+	if err != nil {
+		return 0, "value"
+	}
+	return number, "value"
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,142 @@
+package configurator
+
+import (
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type serverSchema struct {
+	Host    string            `yaml:"host" validate:"required"`
+	Port    int               `yaml:"port" validate:"required,min=1,max=65535"`
+	Mode    string            `yaml:"mode" validate:"oneof=debug release"`
+	Secret  string            `yaml:"secret" validate:"min=8"`
+	Tls     *tlsSchema        `yaml:"tls"`
+	Routes  []routeSchema     `yaml:"routes" validate:"min=1"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+type tlsSchema struct {
+	Cert string `yaml:"cert" validate:"required"`
+}
+
+type routeSchema struct {
+	Path string `yaml:"path" validate:"required"`
+}
+
+func TestValidateSchema(t *testing.T) {
+	assert := assertions.New(t)
+	document := map[string]any{
+		"server": map[string]any{
+			"port":    "http",
+			"mode":    "test",
+			"secret":  "12345",
+			"tls":     map[string]any{},
+			"routes":  []any{map[string]any{"path": "/"}, map[string]any{"pth": "/api"}},
+			"headers": map[string]any{"x-id": "1"},
+			"hots":    "localhost",
+		},
+	}
+	violations := validateSchema("server", document["server"], reflect.TypeOf(serverSchema{}))
+	assert.Equal([]Violation{
+		{Path: "server.host", Message: "is required"},
+		{Path: "server.port", Message: "expected int"},
+		{Path: "server.mode", Message: "must be one of debug release"},
+		{Path: "server.secret", Message: "length must be at least 8"},
+		{Path: "server.tls.cert", Message: "is required"},
+		{Path: "server.routes[1].path", Message: "is required"},
+		{Path: "server.routes[1].pth", Message: "unknown key"},
+		{Path: "server.hots", Message: "unknown key"},
+	}, violations)
+}
+
+func TestValidateSchemaValid(t *testing.T) {
+	assert := assertions.New(t)
+	document := map[string]any{
+		"host":   "localhost",
+		"port":   "8080",
+		"mode":   "release",
+		"routes": []any{map[string]any{"path": "/"}},
+	}
+	violations := validateSchema("", document, reflect.TypeOf(&serverSchema{}))
+	assert.Empty(violations)
+
+	document["port"] = 70000
+	document["routes"] = []any{}
+	violations = validateSchema("", document, reflect.TypeOf(&serverSchema{}))
+	assert.Equal([]Violation{
+		{Path: "port", Message: "value must be at most 65535"},
+		{Path: "routes", Message: "length must be at least 1"},
+	}, violations)
+}
+
+func TestValidateSchemaInline(t *testing.T) {
+	assert := assertions.New(t)
+	type proxySchema struct {
+		tlsSchema `yaml:",inline"`
+		Upstream  string `yaml:"upstream" validate:"required"`
+	}
+	violations := validateSchema("proxy", map[string]any{"cert": "a.pem", "upstrem": "b"}, reflect.TypeOf(proxySchema{}))
+	assert.Equal([]Violation{
+		{Path: "proxy.upstream", Message: "is required"},
+		{Path: "proxy.upstrem", Message: "unknown key"},
+	}, violations)
+	violations = validateSchema("proxy", map[string]any{"upstream": "b"}, reflect.TypeOf(proxySchema{}))
+	assert.Equal([]Violation{{Path: "proxy.cert", Message: "is required"}}, violations)
+}
+
+func TestConfigureValidation(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	called := false
+	agent1 := NewAgent("1", func(r io.Reader, format string) error {
+		called = true
+		return nil
+	}, WithSection("app"), WithSchema(struct {
+		Tag  int    `yaml:"tag" validate:"min=2"`
+		Name string `yaml:"name" validate:"required"`
+	}{}))
+	agent2 := NewTypedAgent("2", "module1", func(c module1Config) error {
+		called = true
+		return nil
+	}, WithSchema(struct {
+		Param string `yaml:"param"`
+		Port  int    `yaml:"port"`
+	}{}))
+	agent1.Require(agent2)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+	err = configurator.Configure()
+	var validationError *ValidationError
+	assert.ErrorAs(err, &validationError)
+	assert.Equal([]Violation{
+		{Path: "module1.hosts", Message: "unknown key"},
+		{Path: "app.tag", Message: "value must be at least 2"},
+		{Path: "app.name", Message: "is required"},
+	}, validationError.Violations)
+	assert.False(called)
+	assert.Equal("configuration is invalid:\n  module1.hosts: unknown key\n  app.tag: value must be at least 2\n  app.name: is required", err.Error())
+}
+
+func TestConfigureValidationPasses(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	config := module1Config{}
+	agent1 := NewTypedAgent("1", "module1", func(c module1Config) error {
+		config = c
+		return nil
+	}, WithSchema(module1Config{}))
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+	err = configurator.Configure()
+	assert.Nil(err)
+	assert.Equal(8080, config.Port)
+}
@@ -0,0 +1,70 @@
+package configurator
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SecretResolver returns the secret a ${scheme:ref} placeholder refers to. It is given
+// the reference, the part after the colon.
+type SecretResolver interface {
+	Resolve(ref string) (string, error)
+}
+
+type SecretResolverFunc func(ref string) (string, error)
+
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// WithSecrets replaces the ${scheme:ref} placeholders found in the string values of the
+// document by the secrets they refer to, before the document is validated and handed to
+// the agents. Placeholders may be part of a longer string, $${ is kept as a literal ${.
+// The schemes file, which reads the file named by ref without its trailing newline, and
+// env, which reads an environment variable, are always available.
+func WithSecrets() Option {
+	return func(c *configuratorImpl) {
+		if c.secrets == nil {
+			c.secrets = map[string]SecretResolver{
+				"file": SecretResolverFunc(readSecretFile),
+				"env":  SecretResolverFunc(lookupSecretEnv),
+			}
+		}
+	}
+}
+
+// WithSecretResolver works like WithSecrets and resolves the placeholders of scheme with
+// resolver, replacing the built-in one if any.
+func WithSecretResolver(scheme string, resolver SecretResolver) Option {
+	return func(c *configuratorImpl) {
+		WithSecrets()(c)
+		c.secrets[scheme] = resolver
+	}
+}
+
+// resolveSecret resolves a ${scheme:ref} placeholder.
+func (c *configuratorImpl) resolveSecret(scheme, ref string) (string, error) {
+	resolver, ok := c.secrets[scheme]
+	if !ok {
+		return "", fmt.Errorf("%w %v", ErrUnknownSecretScheme, scheme)
+	}
+	return resolver.Resolve(ref)
+}
+
+func readSecretFile(path string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSuffix(string(raw), "\n")
+	return strings.TrimSuffix(secret, "\r"), nil
+}
+
+func lookupSecretEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %v is not set", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,98 @@
+package configurator
+
+import (
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type databaseConfig struct {
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Port     int    `yaml:"port"`
+	Url      string `yaml:"url"`
+}
+
+func TestConfigureWithSecrets(t *testing.T) {
+	assert := assertions.New(t)
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "db_pass")
+	assert.Nil(os.WriteFile(secretPath, []byte("s3cret\n"), 0600))
+	t.Setenv("DB_PORT", "5433")
+	path := filepath.Join(dir, "test.config.yaml")
+	assert.Nil(os.WriteFile(path, []byte(strings.Join([]string{
+		"database:",
+		"  user: ${vault:db/user}",
+		"  password: ${file:" + secretPath + "}",
+		"  port: ${env:DB_PORT}",
+		"  url: postgres://${vault:db/user}@localhost/$${db}",
+	}, "\n")), 0640))
+	config := databaseConfig{}
+	agent1 := NewTypedAgent("1", "database", func(c databaseConfig) error {
+		config = c
+		return nil
+	})
+	raw := ""
+	agent2 := NewAgent("2", func(r io.Reader, format string) error {
+		buffer, err := io.ReadAll(r)
+		raw = string(buffer)
+		return err
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1, agent2})
+	assert.Nil(err)
+	vault := SecretResolverFunc(func(ref string) (string, error) {
+		return strings.ReplaceAll(ref, "/", "_"), nil
+	})
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithSecretResolver("vault", vault))
+
+	assert.Nil(configurator.Configure())
+	assert.Equal(databaseConfig{User: "db_user", Password: "s3cret", Port: 5433, Url: "postgres://db_user@localhost/${db}"}, config)
+	assert.Contains(raw, "password: s3cret")
+}
+
+func TestConfigureWithSecretsErrors(t *testing.T) {
+	assert := assertions.New(t)
+	path := filepath.Join(t.TempDir(), "test.config.yaml")
+	assert.Nil(os.WriteFile(path, []byte("database:\n  password: ${file:/not/found}\n  user: ${env:CONFIGUSHKA_NOT_SET}\nhosts:\n  - ${ldap:x}\n"), 0640))
+	updated := false
+	agent1 := NewAgent("1", func(r io.Reader, format string) error {
+		updated = true
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithSecrets())
+
+	err = configurator.Configure()
+	assert.False(updated)
+	assert.ErrorIs(err, os.ErrNotExist)
+	assert.ErrorIs(err, ErrUnknownSecretScheme)
+	var placeholderError *PlaceholderError
+	assert.ErrorAs(err, &placeholderError)
+	assert.Equal("database.password", placeholderError.Path)
+	assert.Equal(strings.Join([]string{
+		`database.password: can not resolve "${file:/not/found}": open /not/found: no such file or directory`,
+		`database.user: can not resolve "${env:CONFIGUSHKA_NOT_SET}": environment variable CONFIGUSHKA_NOT_SET is not set`,
+		`hosts[0]: can not resolve "${ldap:x}": unknown secret scheme ldap`,
+	}, "\n"), err.Error())
+}
+
+func TestConfigureWithoutSecrets(t *testing.T) {
+	assert := assertions.New(t)
+	path := filepath.Join(t.TempDir(), "test.config.yaml")
+	assert.Nil(os.WriteFile(path, []byte("database:\n  password: ${file:/not/found}\n"), 0640))
+	password := ""
+	agent1 := NewTypedAgent("1", "database.password", func(value string) error {
+		password = value
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+	assert.Nil(configurator.Configure())
+	assert.Equal("${file:/not/found}", password)
+}
@@ -0,0 +1,142 @@
+package configurator
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Source supplies configuration values which are layered over the configuration files.
+type Source interface {
+	Load() (map[string]any, error)
+}
+
+type SourceFunc func() (map[string]any, error)
+
+func (f SourceFunc) Load() (map[string]any, error) {
+	return f()
+}
+
+// NewEnvSource maps the environment variables starting with prefix onto document paths.
+// The rest of the name is lower cased and split by separator, so with the prefix "APP_"
+// and the separator "_" the variable APP_MODULE1_PARAM sets module1.param. Values are
+// typed like plain yaml scalars: numbers, booleans and [a, b] lists are recognised, unless
+// the typed value would read differently, so that 01234 or 1.10 are kept as written.
+func NewEnvSource(prefix, separator string) Source {
+	return &envSource{
+		prefix:    prefix,
+		separator: separator,
+		environ:   os.Environ,
+	}
+}
+
+type envSource struct {
+	prefix    string
+	separator string
+	environ   func() []string
+}
+
+func (s *envSource) Load() (map[string]any, error) {
+	document := make(map[string]any)
+	// sorted, so that APP_MODULE1_PARAM deterministically wins over APP_MODULE1
+	variables := s.environ()
+	slices.Sort(variables)
+	for _, variable := range variables {
+		name, value, ok := strings.Cut(variable, "=")
+		if !ok || !strings.HasPrefix(name, s.prefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, s.prefix))
+		path := []string{key}
+		if s.separator != "" {
+			path = strings.Split(key, strings.ToLower(s.separator))
+		}
+		if containsEmpty(path) {
+			continue
+		}
+		setPath(document, path, parseValue(value))
+	}
+	return document, nil
+}
+
+func containsEmpty(path []string) bool {
+	for _, key := range path {
+		if key == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// FlagSource overrides configuration values given as key=value pairs, where the key is a
+// dot separated document path. Values are typed the same way as by NewEnvSource.
+type FlagSource struct {
+	values []string
+}
+
+// NewFlagSource collects the values of the --set arguments in args. Both "--set key=value"
+// and "--set=key=value" are accepted, with one or two dashes, other arguments are ignored.
+func NewFlagSource(args []string) *FlagSource {
+	s := &FlagSource{}
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") || name != "set" {
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				// kept so that Load reports the missing value
+				s.values = append(s.values, args[i])
+				break
+			}
+			i++
+			value = args[i]
+		}
+		s.values = append(s.values, value)
+	}
+	return s
+}
+
+// Bind registers the source as a repeatable flag of the flag set.
+func (s *FlagSource) Bind(flags *flag.FlagSet, name string) {
+	flags.Var(s, name, "override a configuration value, as in path.to.key=value")
+}
+
+func (s *FlagSource) String() string {
+	return strings.Join(s.values, ",")
+}
+
+func (s *FlagSource) Set(value string) error {
+	if _, err := parseAssignment(value); err != nil {
+		return err
+	}
+	s.values = append(s.values, value)
+	return nil
+}
+
+func (s *FlagSource) Load() (map[string]any, error) {
+	document := make(map[string]any)
+	for _, value := range s.values {
+		path, err := parseAssignment(value)
+		if err != nil {
+			return nil, err
+		}
+		_, text, _ := strings.Cut(value, "=")
+		setPath(document, path, parseValue(text))
+	}
+	return document, nil
+}
+
+func parseAssignment(assignment string) ([]string, error) {
+	key, _, ok := strings.Cut(assignment, "=")
+	path := strings.Split(key, ".")
+	if !ok || containsEmpty(path) {
+		return nil, fmt.Errorf("expected path.to.key=value, got %v", assignment)
+	}
+	return path, nil
+}
@@ -0,0 +1,199 @@
+package configurator
+
+import (
+	"context"
+	"flag"
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvSource(t *testing.T) {
+	assert := assertions.New(t)
+	source := &envSource{
+		prefix:    "APP_",
+		separator: "__",
+		environ: func() []string {
+			return []string{
+				"APP_MODULE1__PORT=9090",
+				"APP_MODULE1__MAX_CONNS=1.5",
+				"APP_MODULE1__DEBUG=true",
+				"APP_MODULE1__HOSTS=[gamma, delta]",
+				"APP_MODULE1__PARAM=foo: bar",
+				"APP_MODULE1=ignored",
+				"APP___BROKEN=1",
+				"HOME=/root",
+			}
+		},
+	}
+	document, err := source.Load()
+	assert.Nil(err)
+	assert.Equal(map[string]any{
+		"module1": map[string]any{
+			"port":      9090,
+			"max_conns": 1.5,
+			"debug":     true,
+			"hosts":     []any{"gamma", "delta"},
+			"param":     "foo: bar",
+		},
+	}, document)
+}
+
+func TestConfigureEnvSource(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	t.Setenv("CONFIGUSHKA_TEST_MODULE1_PORT", "9090")
+	t.Setenv("CONFIGUSHKA_TEST_APP_NAME", "test")
+	config := module1Config{}
+	agent1 := NewTypedAgent("1", "module1", func(c module1Config) error {
+		config = c
+		return nil
+	})
+	raw := ""
+	agent2 := NewAgent("2", func(r io.Reader, format string) error {
+		buffer, ioerr := io.ReadAll(r)
+		raw = string(buffer)
+		return ioerr
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1, agent2})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithSource(NewEnvSource("CONFIGUSHKA_TEST_", "_")))
+
+	err = configurator.Configure()
+	assert.Nil(err)
+	assert.Equal(module1Config{Param: "value", Port: 9090, Hosts: []string{"alpha", "beta"}}, config)
+	document, err := decodeDocument([]byte(raw), "yaml")
+	assert.Nil(err)
+	assert.Equal(map[string]any{"tag": 1, "name": "test"}, document["app"])
+}
+
+func TestFlagSource(t *testing.T) {
+	assert := assertions.New(t)
+	source := NewFlagSource([]string{
+		"-v",
+		"--set", "module1.param=foo",
+		"--set=module1.port=9090",
+		"-set", "app.debug=true",
+		"module1.ignored=1",
+		"--",
+		"--set", "module1.param=bar",
+	})
+	document, err := source.Load()
+	assert.Nil(err)
+	assert.Equal(map[string]any{
+		"module1": map[string]any{"param": "foo", "port": 9090},
+		"app":     map[string]any{"debug": true},
+	}, document)
+
+	document, err = NewFlagSource([]string{
+		"--set", "app.zip=01234",
+		"--set", "app.version=1.10",
+		"--set", "app.ratio=0.5",
+		"--set", "app.enabled=True",
+		"--set", "app.codes=[007, 8]",
+	}).Load()
+	assert.Nil(err)
+	assert.Equal(map[string]any{"app": map[string]any{
+		"zip":     "01234",
+		"version": "1.10",
+		"ratio":   0.5,
+		"enabled": "True",
+		"codes":   []any{"007", 8},
+	}}, document)
+
+	_, err = NewFlagSource([]string{"--set", "module1.param"}).Load()
+	assert.NotNil(err)
+	_, err = NewFlagSource([]string{"-v", "--set"}).Load()
+	assert.EqualError(err, "expected path.to.key=value, got --set")
+	_, err = NewFlagSource([]string{"--set", "module1..param=1"}).Load()
+	assert.NotNil(err)
+}
+
+func TestFlagSourceBind(t *testing.T) {
+	assert := assertions.New(t)
+	source := NewFlagSource(nil)
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	source.Bind(flags, "set")
+	err := flags.Parse([]string{"-set", "module1.param=foo", "--set", "module1.hosts=[gamma]"})
+	assert.Nil(err)
+	document, err := source.Load()
+	assert.Nil(err)
+	assert.Equal(map[string]any{"module1": map[string]any{"param": "foo", "hosts": []any{"gamma"}}}, document)
+
+	err = flags.Parse([]string{"-set", "broken"})
+	assert.NotNil(err)
+}
+
+func TestConfigureSourcePrecedence(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	t.Setenv("CONFIGUSHKA_TEST_MODULE1_PORT", "9090")
+	t.Setenv("CONFIGUSHKA_TEST_MODULE1_PARAM", "env")
+	config := module1Config{}
+	agent1 := NewTypedAgent("1", "module1", func(c module1Config) error {
+		config = c
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml",
+		WithSource(NewEnvSource("CONFIGUSHKA_TEST_", "_")),
+		WithSource(NewFlagSource([]string{"--set", "module1.param=flag"})),
+	)
+
+	err = configurator.Configure()
+	assert.Nil(err)
+	assert.Equal(module1Config{Param: "flag", Port: 9090, Hosts: []string{"alpha", "beta"}}, config)
+}
+
+func TestConfigureFlagSourceKeepsText(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	config := module1Config{}
+	agent1 := NewTypedAgent("1", "module1", func(c module1Config) error {
+		config = c
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml",
+		WithSource(NewFlagSource([]string{"--set", "module1.param=01234", "--set", "module1.port=09090", "--set", "module1.hosts=[1.10]"})),
+	)
+
+	err = configurator.Configure()
+	assert.Nil(err)
+	assert.Equal(module1Config{Param: "01234", Port: 9090, Hosts: []string{"1.10"}}, config)
+}
+
+func TestConfigureSourceOverFormatWithoutEncoding(t *testing.T) {
+	assert := assertions.New(t)
+	path := filepath.Join(t.TempDir(), "test.config.ini")
+	assert.Nil(os.WriteFile(path, []byte("[module1]\nparam = value\nport = 8080\n"), 0640))
+	t.Setenv("CONFIGUSHKA_TEST_MODULE1_POOL_SIZE", "4")
+	config := module1Config{}
+	agent1 := NewTypedAgent("1", "module1", func(c module1Config) error {
+		config = c
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "ini", WithSource(NewEnvSource("CONFIGUSHKA_TEST_", "_")))
+	assert.Nil(configurator.Configure())
+	assert.Equal(module1Config{Param: "value", Port: 8080}, config)
+
+	// only an agent reading the raw document needs it encoded
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	assert.Nil(registry.Register(agent2))
+	report, err := configurator.ConfigureReport(context.Background())
+	assert.NotNil(err)
+	outcome, ok := report.Agent("2")
+	assert.True(ok)
+	assert.Equal(Failed, outcome.Decision)
+	assert.NotNil(outcome.Err)
+}
@@ -0,0 +1,84 @@
+package configurator
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// WithTransactional updates the agents in two phases. First every update callback runs and
+// only when all of them succeed the agents are committed, in the same order. If a callback
+// or a commit fails, every agent prepared so far is rolled back in reverse order. Agents
+// without WithTransaction apply their settings in the callback and can not be rolled back.
+func WithTransactional() Option {
+	return func(c *configuratorImpl) {
+		c.transactional = true
+	}
+}
+
+func (c *configuratorImpl) configureTransaction(ctx context.Context, agents []Agent, conf *snapshot, recorder *recorder) error {
+	var mutex sync.Mutex
+	prepared := make([]Agent, 0, len(agents))
+	unchanged := make([]Agent, 0)
+	fingerprints := make(map[string]string, len(agents))
+	err := c.run(ctx, agents, func(agent Agent) error {
+		fingerprint, changed, reason := c.detectChange(agent, conf, recorder)
+		if !changed {
+			mutex.Lock()
+			defer mutex.Unlock()
+			unchanged = append(unchanged, agent)
+			recorder.record(agent, Unchanged, reason, nil)
+			return nil
+		}
+		if err := agent.prepare(ctx, conf); err != nil {
+			agent.setFingerprint("")
+			recorder.record(agent, Failed, reason, err)
+			return err
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		prepared = append(prepared, agent)
+		fingerprints[agent.moduleName()] = fingerprint
+		recorder.record(agent, Updated, reason, nil)
+		return nil
+	})
+	if err == nil {
+		for _, agent := range prepared {
+			if commitErr := agent.commit(); commitErr != nil {
+				agent.setFingerprint("")
+				recorder.record(agent, Failed, "", commitErr)
+				err = &AgentError{Agent: agent.moduleName(), Err: commitErr}
+				break
+			}
+		}
+	}
+	if err != nil {
+		for _, agent := range prepared {
+			if recorder.decision(agent.moduleName()) == Updated {
+				recorder.record(agent, RolledBack, "", nil)
+			}
+		}
+		if rollbackErr := rollback(prepared); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	for _, agent := range prepared {
+		agent.setFingerprint(fingerprints[agent.moduleName()])
+		agent.markConfigured()
+	}
+	for _, agent := range unchanged {
+		agent.markUnchanged()
+	}
+	return nil
+}
+
+func rollback(agents []Agent) error {
+	errs := make([]error, 0)
+	for i := len(agents) - 1; i >= 0; i-- {
+		if err := agents[i].rollback(); err != nil {
+			errs = append(errs, &AgentError{Agent: agents[i].moduleName(), Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,114 @@
+package configurator
+
+import (
+	"errors"
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type transactionLog struct {
+	events []string
+}
+
+func (l *transactionLog) agent(name string, prepareErr, commitErr error) Agent {
+	return NewAgent(name, func(r io.Reader, format string) error {
+		l.events = append(l.events, "prepare "+name)
+		return prepareErr
+	}, WithTransaction(func() error {
+		l.events = append(l.events, "commit "+name)
+		return commitErr
+	}, func() error {
+		l.events = append(l.events, "rollback "+name)
+		return nil
+	}))
+}
+
+func TestConfigureTransactional(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	now := time.Now()
+	log := &transactionLog{}
+	agent1 := log.agent("1", nil, nil)
+	agent2 := log.agent("2", nil, nil)
+	agent1.Require(agent2)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithTransactional())
+
+	err = configurator.Configure()
+	assert.Nil(err)
+	assert.Equal([]string{"prepare 2", "prepare 1", "commit 2", "commit 1"}, log.events)
+	assert.True(agent1.isConfigured(now))
+	assert.True(agent2.isConfigured(now))
+}
+
+func TestConfigureTransactionalPrepareError(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	now := time.Now()
+	someError := errors.New("some error")
+	log := &transactionLog{}
+	agent1 := log.agent("1", someError, nil)
+	agent2 := log.agent("2", nil, nil)
+	agent3 := log.agent("3", nil, nil)
+	agent1.Require(agent2)
+	agent1.Require(agent3)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithTransactional())
+
+	err = configurator.Configure()
+	assert.Equal(&AgentError{Agent: "1", Err: someError}, err)
+	assert.Equal([]string{"prepare 2", "prepare 3", "prepare 1", "rollback 3", "rollback 2"}, log.events)
+	assert.False(agent1.isConfigured(now))
+	assert.False(agent2.isConfigured(now))
+	assert.False(agent3.isConfigured(now))
+}
+
+func TestConfigureTransactionalCommitError(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	now := time.Now()
+	someError := errors.New("some error")
+	log := &transactionLog{}
+	agent1 := log.agent("1", nil, nil)
+	agent2 := log.agent("2", nil, someError)
+	agent3 := log.agent("3", nil, nil)
+	agent1.Require(agent2)
+	agent2.Require(agent3)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml", WithTransactional(), WithParallelism(2))
+
+	err = configurator.Configure()
+	assert.Equal(&AgentError{Agent: "2", Err: someError}, err)
+	assert.Equal([]string{
+		"prepare 3", "prepare 2", "prepare 1",
+		"commit 3", "commit 2",
+		"rollback 1", "rollback 2", "rollback 3",
+	}, log.events)
+	assert.False(agent1.isConfigured(now))
+	assert.False(agent3.isConfigured(now))
+}
+
+func TestConfigureTransactionCommittedImmediately(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	someError := errors.New("some error")
+	log := &transactionLog{}
+	agent1 := log.agent("1", nil, someError)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+	err = configurator.Configure()
+	assert.Equal(&AgentError{Agent: "1", Err: someError}, err)
+	assert.Equal([]string{"prepare 1", "commit 1", "rollback 1"}, log.events)
+}
@@ -0,0 +1,38 @@
+package configurator
+
+import (
+	"context"
+	"fmt"
+)
+
+// NewTypedAgent creates an agent which decodes the section of the configuration document
+// into T and passes it to updateCallback. The section is a dot separated path of keys and
+// T is populated according to its yaml tags whatever the document format is.
+func NewTypedAgent[T any](name, section string, updateCallback func(T) error, options ...AgentOption) Agent {
+	agent := NewAgent(name, nil, append([]AgentOption{WithSection(section)}, options...)...).(*agentImpl)
+	agent.apply = func(ctx context.Context, s *snapshot) error {
+		node, err := s.section(section)
+		if err != nil {
+			return err
+		}
+		var value T
+		if err := convert(node, &value); err != nil {
+			return err
+		}
+		return updateCallback(value)
+	}
+	return agent
+}
+
+// section returns the subtree of the document addressed by a dot separated path.
+func (s *snapshot) section(section string) (any, error) {
+	document, err := s.tree()
+	if err != nil {
+		return nil, err
+	}
+	node, ok := lookupSection(document, section)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrSectionNotFound, section)
+	}
+	return node, nil
+}
@@ -0,0 +1,135 @@
+package configurator
+
+import (
+	assertions "github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type module1Config struct {
+	Param string   `yaml:"param"`
+	Port  int      `yaml:"port"`
+	Hosts []string `yaml:"hosts"`
+}
+
+func TestTypedAgent(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.yaml")
+	assert.Nil(err)
+	now := time.Now()
+	config := module1Config{}
+	agent1 := NewTypedAgent("1", "module1", func(c module1Config) error {
+		config = c
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "yaml")
+
+	err = configurator.Configure()
+	assert.Nil(err)
+	assert.Equal(module1Config{Param: "value", Port: 8080, Hosts: []string{"alpha", "beta"}}, config)
+	assert.True(agent1.isConfigured(now))
+}
+
+func TestTypedAgentJson(t *testing.T) {
+	assert := assertions.New(t)
+	path, err := filepath.Abs("../../test/configurator/test.config.json")
+	assert.Nil(err)
+	config := module1Config{}
+	agent1 := NewTypedAgent("1", "module1", func(c module1Config) error {
+		config = c
+		return nil
+	})
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewLocalConfigurator(registry, []string{path}, "json")
+
+	err = configurator.Configure()
+	assert.Nil(err)
+	assert.Equal(module1Config{Param: "value", Port: 8080, Hosts: []string{"alpha", "beta"}}, config)
+}
+
+func TestTypedAgentNestedSection(t *testing.T) {
+	assert := assertions.New(t)
+	tag := 0
+	agent1 := NewTypedAgent("1", "app.tag", func(value int) error {
+		tag = value
+		return nil
+	})
+	reader := strings.NewReader("app:\n  tag: 7\n")
+	err := agent1.update(reader, "yaml")
+	assert.Nil(err)
+	assert.Equal(7, tag)
+}
+
+func TestTypedAgentWholeDocument(t *testing.T) {
+	assert := assertions.New(t)
+	config := map[string]module1Config{}
+	agent1 := NewTypedAgent("1", "", func(value map[string]module1Config) error {
+		config = value
+		return nil
+	})
+	reader := strings.NewReader("module1:\n  param: value\n  port: 8080\n")
+	err := agent1.update(reader, "yaml")
+	assert.Nil(err)
+	assert.Equal(map[string]module1Config{"module1": {Param: "value", Port: 8080}}, config)
+}
+
+func TestTypedAgentSectionNotFound(t *testing.T) {
+	assert := assertions.New(t)
+	called := false
+	agent1 := NewTypedAgent("1", "module2", func(c module1Config) error {
+		called = true
+		return nil
+	})
+	reader := strings.NewReader("app:\n  tag: 7\n")
+	err := agent1.update(reader, "yaml")
+	assert.NotNil(err)
+	assert.False(called)
+}
+
+func TestTypedAgentWrongType(t *testing.T) {
+	assert := assertions.New(t)
+	agent1 := NewTypedAgent("1", "app", func(c module1Config) error { return nil })
+	reader := strings.NewReader("app:\n  port: abc\n")
+	err := agent1.update(reader, "yaml")
+	assert.NotNil(err)
+}
+
+func TestTypedAgentKeepsQuotedStrings(t *testing.T) {
+	assert := assertions.New(t)
+	type config struct {
+		Password *string `yaml:"password"`
+		Name     string  `yaml:"name"`
+		Port     int     `yaml:"port"`
+		Debug    *bool   `yaml:"debug"`
+		Extra    any     `yaml:"extra"`
+	}
+	for format, raw := range map[string]string{
+		"yaml": "app:\n  password: \"null\"\n  name: \"~\"\n  port: \"8080\"\n  debug: \"true\"\n  extra: \"12\"\n",
+		"json": `{"app": {"password": "null", "name": "~", "port": "8080", "debug": "true", "extra": "12"}}`,
+		"ini":  "[app]\npassword = null\nname = ~\nport = 8080\ndebug = true\nextra = 12\n",
+	} {
+		value := config{}
+		agent1 := NewTypedAgent("1", "app", func(c config) error {
+			value = c
+			return nil
+		})
+		registry, err := NewModuleRegistry([]Agent{agent1})
+		assert.Nil(err)
+		path := filepath.Join(t.TempDir(), "test.config."+format)
+		assert.Nil(os.WriteFile(path, []byte(raw), 0640))
+		err = NewLocalConfigurator(registry, []string{path}, format).Configure()
+		assert.Nil(err, format)
+		assert.NotNil(value.Password, format)
+		if value.Password != nil {
+			assert.Equal("null", *value.Password, format)
+		}
+		debug := true
+		assert.Equal(config{Password: value.Password, Name: "~", Port: 8080, Debug: &debug, Extra: "12"}, value, format)
+	}
+}
@@ -0,0 +1,154 @@
+package configurator
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// WatchingConfigurator is a Configurator that re-runs Configure whenever one of its
+// configuration files changes on disk.
+type WatchingConfigurator interface {
+	Configurator
+	// Watch observes the configuration paths until done is closed. Every reload error
+	// is delivered to the returned channel, which is closed once watching stops.
+	Watch(done <-chan struct{}) <-chan error
+}
+
+// defaultPollInterval replaces a pollInterval which is not positive.
+const defaultPollInterval = time.Second
+
+// NewWatchingConfigurator works like NewLocalConfigurator and additionally watches
+// configPaths. Inotify is used where available, otherwise the files are polled every
+// pollInterval, every second when pollInterval is not positive. A file in a directory
+// holding a ..data link, as Kubernetes mounts a ConfigMap, is also reloaded when the link
+// is swapped.
+func NewWatchingConfigurator(registry Registry, configPaths []string, format string, pollInterval time.Duration, options ...Option) WatchingConfigurator {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	configurator := &watchingConfiguratorImpl{
+		configuratorImpl: NewLocalConfigurator(registry, configPaths, format, options...).(*configuratorImpl),
+		pollInterval:     pollInterval,
+	}
+	return configurator
+}
+
+type watchingConfiguratorImpl struct {
+	*configuratorImpl
+	pollInterval time.Duration
+}
+
+func (c *watchingConfiguratorImpl) Watch(done <-chan struct{}) <-chan error {
+	errs := make(chan error)
+	go func() {
+		defer close(errs)
+		watcher := newFileWatcher(c.paths, c.pollInterval)
+		defer func() {
+			_ = watcher.Close()
+		}()
+		for {
+			select {
+			case <-done:
+				return
+			case _, ok := <-watcher.Events():
+				if !ok {
+					_ = watcher.Close()
+					watcher = newPollWatcher(c.paths, c.pollInterval)
+					continue
+				}
+				if err := c.reload(); err != nil {
+					select {
+					case errs <- err:
+					case <-done:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return errs
+}
+
+func (c *watchingConfiguratorImpl) reload() error {
+	_, err := c.configure(context.Background(), true)
+	return err
+}
+
+type fileWatcher interface {
+	Events() <-chan struct{}
+	Close() error
+}
+
+func newPollWatcher(paths []string, interval time.Duration) fileWatcher {
+	w := &pollWatcher{
+		paths:    paths,
+		interval: interval,
+		events:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	states := w.stat()
+	go w.loop(states)
+	return w
+}
+
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+type pollWatcher struct {
+	paths    []string
+	interval time.Duration
+	events   chan struct{}
+	done     chan struct{}
+}
+
+func (w *pollWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	return nil
+}
+
+func (w *pollWatcher) loop(states []fileState) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			current := w.stat()
+			for i := range current {
+				if current[i] != states[i] {
+					notify(w.events)
+					break
+				}
+			}
+			states = current
+		}
+	}
+}
+
+func (w *pollWatcher) stat() []fileState {
+	states := make([]fileState, len(w.paths))
+	for i, path := range w.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		states[i] = fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+	}
+	return states
+}
+
+func notify(events chan struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
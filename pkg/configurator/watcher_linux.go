@@ -0,0 +1,97 @@
+//go:build linux
+
+package configurator
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE
+
+const kubernetesDataLink = "..data"
+
+func newFileWatcher(paths []string, interval time.Duration) fileWatcher {
+	watcher, err := newInotifyWatcher(paths)
+	if err != nil {
+		return newPollWatcher(paths, interval)
+	}
+	return watcher
+}
+
+type inotifyWatcher struct {
+	file   *os.File
+	names  map[int32]map[string]bool
+	events chan struct{}
+}
+
+func newInotifyWatcher(paths []string) (fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		names:  make(map[int32]map[string]bool),
+		events: make(chan struct{}, 1),
+	}
+	// directories are watched instead of files, so that replacing a file by rename is noticed too
+	for _, path := range paths {
+		absolute, err := filepath.Abs(path)
+		if err != nil {
+			_ = w.file.Close()
+			return nil, err
+		}
+		wd, err := syscall.InotifyAddWatch(fd, filepath.Dir(absolute), inotifyMask)
+		if err != nil {
+			_ = w.file.Close()
+			return nil, err
+		}
+		if w.names[int32(wd)] == nil {
+			w.names[int32(wd)] = make(map[string]bool)
+		}
+		w.names[int32(wd)][filepath.Base(absolute)] = true
+		// Kubernetes updates a mounted ConfigMap by renaming a new link over ..data, which
+		// the files of the directory link through
+		w.names[int32(wd)][kubernetesDataLink] = true
+	}
+	go w.loop()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
+
+func (w *inotifyWatcher) loop() {
+	defer close(w.events)
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buffer)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			name := string(buffer[start : start+int(event.Len)])
+			for i := 0; i < len(name); i++ {
+				if name[i] == 0 {
+					name = name[:i]
+					break
+				}
+			}
+			if w.names[event.Wd][name] {
+				notify(w.events)
+			}
+			offset = start + int(event.Len)
+		}
+	}
+}
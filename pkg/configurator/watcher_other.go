@@ -0,0 +1,9 @@
+//go:build !linux
+
+package configurator
+
+import "time"
+
+func newFileWatcher(paths []string, interval time.Duration) fileWatcher {
+	return newPollWatcher(paths, interval)
+}
@@ -0,0 +1,98 @@
+package configurator
+
+import (
+	assertions "github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchReconfigure(t *testing.T) {
+	assert := assertions.New(t)
+	path := filepath.Join(t.TempDir(), "test.config.yaml")
+	assert.Nil(os.WriteFile(path, []byte("app:\n  tag: 1\n"), 0640))
+	configs := make(chan string, 10)
+	agent1 := NewAgent("1", func(r io.Reader, format string) error {
+		buffer, err := io.ReadAll(r)
+		configs <- string(buffer)
+		return err
+	})
+	agent2 := NewAgent("2", func(r io.Reader, format string) error { return nil })
+	agent1.Require(agent2)
+	registry, err := NewModuleRegistry([]Agent{agent1})
+	assert.Nil(err)
+	configurator := NewWatchingConfigurator(registry, []string{path}, "yaml", 10*time.Millisecond)
+	assert.Nil(configurator.Configure())
+	assert.Equal("app:\n  tag: 1\n", <-configs)
+
+	done := make(chan struct{})
+	errs := configurator.Watch(done)
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(os.WriteFile(path, []byte("app:\n  tag: 2\n"), 0640))
+	select {
+	case config := <-configs:
+		assert.Equal("app:\n  tag: 2\n", config)
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration was not reloaded")
+	}
+	close(done)
+	for range errs {
+	}
+}
+
+func TestPollWatcher(t *testing.T) {
+	assert := assertions.New(t)
+	path := filepath.Join(t.TempDir(), "test.config.yaml")
+	watcher := newPollWatcher([]string{path}, 10*time.Millisecond)
+	defer watcher.Close()
+
+	assert.Nil(os.WriteFile(path, []byte("app:\n  tag: 1\n"), 0640))
+	select {
+	case <-watcher.Events():
+	case <-time.After(5 * time.Second):
+		t.Fatal("file creation was not noticed")
+	}
+	assert.Nil(os.Remove(path))
+	select {
+	case <-watcher.Events():
+	case <-time.After(5 * time.Second):
+		t.Fatal("file removal was not noticed")
+	}
+}
+
+func TestWatchDefaultPollInterval(t *testing.T) {
+	assert := assertions.New(t)
+	registry, err := NewModuleRegistry(nil)
+	assert.Nil(err)
+	configurator := NewWatchingConfigurator(registry, []string{"test.config.yaml"}, "yaml", 0)
+	assert.Equal(defaultPollInterval, configurator.(*watchingConfiguratorImpl).pollInterval)
+}
+
+func TestFileWatcherDataLink(t *testing.T) {
+	assert := assertions.New(t)
+	dir := t.TempDir()
+	// the layout of a ConfigMap mounted by Kubernetes
+	version := func(name, config string) {
+		assert.Nil(os.Mkdir(filepath.Join(dir, name), 0750))
+		assert.Nil(os.WriteFile(filepath.Join(dir, name, "test.config.yaml"), []byte(config), 0640))
+		assert.Nil(os.Symlink(name, filepath.Join(dir, "..data_tmp")))
+		assert.Nil(os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	}
+	version("..v1", "app:\n  tag: 1\n")
+	path := filepath.Join(dir, "test.config.yaml")
+	assert.Nil(os.Symlink(filepath.Join("..data", "test.config.yaml"), path))
+	watcher := newFileWatcher([]string{path}, 10*time.Millisecond)
+	defer watcher.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	version("..v2", "app:\n  tag: 22\n")
+	select {
+	case <-watcher.Events():
+	case <-time.After(5 * time.Second):
+		t.Fatal("link swap was not noticed")
+	}
+}
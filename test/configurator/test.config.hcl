@@ -0,0 +1,9 @@
+app {
+  tag = 1
+}
+
+module1 {
+  param = "value"
+  port = 8080
+  hosts = ["alpha", "beta"]
+}